@@ -0,0 +1,455 @@
+/*
+Copyright © 2020 Rob Allen <rob@akrabat.com>
+
+Use of this source code is governed by the MIT
+license that can be found in the LICENSE file or at
+https://akrabat.com/license/mit.
+*/
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/akrabat/rodeo/internal"
+	"github.com/akrabat/rodeo/internal/uploaddb"
+	"github.com/spf13/cobra"
+	"gopkg.in/masci/flickr.v2"
+	"gopkg.in/masci/flickr.v2/photos"
+	"gopkg.in/masci/flickr.v2/photosets"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// photosPerPage is how many photos are requested per page when cursoring
+// through flickr.photos.search.
+const photosPerPage = 500
+
+func init() {
+	rootCmd.AddCommand(pullCmd)
+
+	pullCmd.Flags().String("since", "", "Only pull photos uploaded on or after this date (YYYY-MM-DD)")
+	pullCmd.Flags().String("album", "", "Only pull photos from this album, by name or id")
+	pullCmd.Flags().Bool("metadata-only", false, "Write sidecar metadata without downloading originals")
+}
+
+// pullCmd represents the pull command
+var pullCmd = &cobra.Command{
+	Use:   "pull",
+	Short: "Mirror your Flickr photostream to a local directory",
+	Long: `Mirror your Flickr photostream (or a single album) to a local directory.
+
+Originals are downloaded into pull.directory, organised as YYYY/MM/<photoid>.<ext>
+by upload date, alongside a <photoid>.json sidecar recording the photo's
+title, description, tags, visibility and album membership. Photos already
+known to the upload registry (see 'rodeo uploads') are recognised by their
+Flickr id and are not re-downloaded, so 'rodeo pull' can run repeatedly as
+an incremental backup.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := GetConfig()
+
+		apiKey := config.Flickr.ApiKey
+		apiSecret := config.Flickr.ApiSecret
+		oauthToken := config.Flickr.OauthToken
+		oauthTokenSecret := config.Flickr.OauthSecret
+		if apiKey == "" || apiSecret == "" || oauthToken == "" || oauthTokenSecret == "" {
+			fmt.Println("Unable to continue. Please run the 'rodeo authenticate' command first")
+			os.Exit(2)
+		}
+
+		directory := config.Pull.Directory
+		if directory == "" {
+			fmt.Println("Error: pull.directory needs to be configured.")
+			os.Exit(2)
+		}
+
+		since, err := cmd.Flags().GetString("since")
+		if err != nil {
+			since = ""
+		}
+		var minUploadDate int64
+		if since != "" {
+			t, err := time.Parse("2006-01-02", since)
+			if err != nil {
+				fmt.Println("Error: --since must be in YYYY-MM-DD format:", err)
+				os.Exit(2)
+			}
+			minUploadDate = t.Unix()
+		}
+
+		album, err := cmd.Flags().GetString("album")
+		if err != nil {
+			album = ""
+		}
+
+		metadataOnly, err := cmd.Flags().GetBool("metadata-only")
+		if err != nil {
+			metadataOnly = false
+		}
+
+		client := flickr.NewFlickrClient(apiKey, apiSecret)
+		client.OAuthToken = oauthToken
+		client.OAuthTokenSecret = oauthTokenSecret
+
+		dbs := newUploadDBSet()
+		defer dbs.closeAll()
+
+		var albumId string
+		if album != "" {
+			albumId, err = resolveAlbumId(client, album)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(2)
+			}
+		}
+
+		pulled := 0
+		skipped := 0
+
+		err = forEachRemotePhoto(client, albumId, minUploadDate, func(p remotePhoto) error {
+			dir := filepath.Join(directory, p.UploadDate.Format("2006"), p.UploadDate.Format("01"))
+
+			// Key the db path off a path inside dir, so pull lands in the
+			// same per-directory database that upload.go uses when
+			// upload.store_upload_list_in_image_dir is enabled.
+			db, err := dbs.get(filepath.Join(dir, p.Id), config.Upload.StoreUploadListInImageDir)
+			if err != nil {
+				return err
+			}
+
+			if existing, err := db.FindByPhotoID(p.Id); err == nil && existing != nil && !metadataOnly {
+				skipped++
+				return nil
+			}
+
+			if err := os.MkdirAll(dir, 0775); err != nil {
+				return err
+			}
+
+			if err := writeSidecar(dir, p); err != nil {
+				return err
+			}
+
+			if !metadataOnly {
+				destPath, sha, size, err := downloadOriginal(dir, p)
+				if err != nil {
+					return err
+				}
+
+				err = db.Record(uploaddb.Upload{
+					SHA256:     sha,
+					PhotoID:    p.Id,
+					Filename:   filepath.Base(destPath),
+					Size:       size,
+					Mtime:      p.UploadDate.Unix(),
+					UploadedAt: p.UploadDate.Unix(),
+					Title:      p.Title,
+					Tags:       strings.Join(p.Tags, ","),
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			pulled++
+			fmt.Printf("\r\x1b[K[%d pulled, %d already known] %s", pulled, skipped, p.Id)
+			return nil
+		})
+		fmt.Println()
+
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(2)
+		}
+
+		fmt.Printf("Done: %d photos pulled, %d already known.\n", pulled, skipped)
+	},
+}
+
+// remotePhoto is the subset of a Flickr photo's metadata that `pull` cares
+// about.
+type remotePhoto struct {
+	Id          string
+	Title       string
+	Description string
+	Tags        []string
+	Visibility  string
+	Albums      []string
+	UploadDate  time.Time
+	OriginalURL string
+}
+
+// resolveAlbumId looks up a photoset by name or id, returning its id.
+func resolveAlbumId(client *flickr.FlickrClient, album string) (string, error) {
+	page := 1
+	for {
+		list, err := photosets.GetList(client, true, "", page)
+		if err != nil {
+			return "", fmt.Errorf("listing albums: %w", err)
+		}
+
+		for _, set := range list.Photosets.Items {
+			if set.Id == album || set.Title == album {
+				return set.Id, nil
+			}
+		}
+
+		if page >= list.Photosets.Pages {
+			return "", fmt.Errorf("no album found matching %q", album)
+		}
+		page++
+	}
+}
+
+// photosSearchResponse is the flickr.photos.search response. The vendored
+// flickr.v2 library has no photos.Search wrapper (unlike
+// flickr.photosets.getList/getPhotos), so this is hand-rolled the same way
+// photosets.GetList builds its request, via client.Args and DoGet.
+type photosSearchResponse struct {
+	flickr.BasicResponse
+	Photos struct {
+		Page  int `xml:"page,attr"`
+		Pages int `xml:"pages,attr"`
+		Items []struct {
+			Id string `xml:"id,attr"`
+		} `xml:"photo"`
+	} `xml:"photos"`
+}
+
+// searchPhotos cursors the caller's own photostream via flickr.photos.search,
+// returning the ids of photos uploaded on or after minUploadDate.
+func searchPhotos(client *flickr.FlickrClient, minUploadDate int64, page int) (*photosSearchResponse, error) {
+	client.Init()
+	client.Args.Set("method", "flickr.photos.search")
+	client.Args.Set("user_id", "me")
+	if minUploadDate > 0 {
+		client.Args.Set("min_upload_date", strconv.FormatInt(minUploadDate, 10))
+	}
+	client.Args.Set("per_page", strconv.Itoa(photosPerPage))
+	if page > 1 {
+		client.Args.Set("page", strconv.Itoa(page))
+	}
+	client.OAuthSign()
+
+	response := &photosSearchResponse{}
+	err := flickr.DoGet(client, response)
+	return response, err
+}
+
+// forEachRemotePhoto cursors through the photostream (or a single album)
+// using flickr.photos.search's min_upload_date paging, calling fn once per
+// photo found at or after minUploadDate.
+func forEachRemotePhoto(client *flickr.FlickrClient, albumId string, minUploadDate int64, fn func(remotePhoto) error) error {
+	page := 1
+	for {
+		var photoIds []string
+		var hasMore bool
+
+		if albumId != "" {
+			resp, err := photosets.GetPhotos(client, true, albumId, "", page)
+			if err != nil {
+				return fmt.Errorf("listing album photos: %w", err)
+			}
+			for _, p := range resp.Photoset.Photos {
+				photoIds = append(photoIds, p.Id)
+			}
+			hasMore = page < resp.Photoset.Pages
+		} else {
+			resp, err := searchPhotos(client, minUploadDate, page)
+			if err != nil {
+				return fmt.Errorf("searching photos: %w", err)
+			}
+			for _, p := range resp.Photos.Items {
+				photoIds = append(photoIds, p.Id)
+			}
+			hasMore = page < resp.Photos.Pages
+		}
+
+		for _, photoId := range photoIds {
+			photo, err := fetchRemotePhoto(client, photoId)
+			if err != nil {
+				return err
+			}
+			if photo.UploadDate.Unix() < minUploadDate {
+				continue
+			}
+			if err := fn(photo); err != nil {
+				return err
+			}
+		}
+
+		if !hasMore {
+			return nil
+		}
+		page++
+	}
+}
+
+// fetchRemotePhoto fetches the full metadata and original-size download URL
+// for a single photo id.
+func fetchRemotePhoto(client *flickr.FlickrClient, photoId string) (remotePhoto, error) {
+	info, err := photos.GetInfo(client, photoId, "")
+	if err != nil {
+		return remotePhoto{}, fmt.Errorf("getting info for %s: %w", photoId, err)
+	}
+
+	sizes, err := photos.GetSizes(client, photoId)
+	if err != nil {
+		return remotePhoto{}, fmt.Errorf("getting sizes for %s: %w", photoId, err)
+	}
+
+	var originalURL string
+	for _, size := range sizes.Sizes {
+		if size.Label == "Original" {
+			originalURL = size.Source
+		}
+	}
+
+	uploadDate, _ := strconv.ParseInt(info.Photo.DateUploaded, 10, 64)
+
+	albums, err := getPhotoAlbums(client, photoId)
+	if err != nil {
+		return remotePhoto{}, fmt.Errorf("getting albums for %s: %w", photoId, err)
+	}
+
+	return remotePhoto{
+		Id:          photoId,
+		Title:       info.Photo.Title,
+		Description: info.Photo.Description,
+		Tags:        splitTags(info.Photo.Tags),
+		Visibility:  visibilityFromInfo(info),
+		Albums:      albums,
+		UploadDate:  time.Unix(uploadDate, 0),
+		OriginalURL: originalURL,
+	}, nil
+}
+
+// photoContextsResponse is the flickr.photos.getAllContexts response. The
+// vendored flickr.v2 library has no wrapper for this method, so it's
+// hand-rolled the same way searchPhotos and setLicense build their requests.
+type photoContextsResponse struct {
+	flickr.BasicResponse
+	Sets []struct {
+		Id    string `xml:"id,attr"`
+		Title string `xml:"title,attr"`
+	} `xml:"set"`
+}
+
+// getPhotoAlbums returns the names of every album (photoset) that photoId
+// belongs to, via flickr.photos.getAllContexts.
+func getPhotoAlbums(client *flickr.FlickrClient, photoId string) ([]string, error) {
+	client.Init()
+	client.Args.Set("method", "flickr.photos.getAllContexts")
+	client.Args.Set("photo_id", photoId)
+	client.OAuthSign()
+
+	response := &photoContextsResponse{}
+	if err := flickr.DoGet(client, response); err != nil {
+		return nil, err
+	}
+	if response.HasErrors() {
+		return nil, fmt.Errorf("getting albums for %s: %s", photoId, response.ErrorMsg())
+	}
+
+	var albums []string
+	for _, set := range response.Sets {
+		albums = append(albums, set.Title)
+	}
+	return albums, nil
+}
+
+func splitTags(tags []photos.Tag) []string {
+	var result []string
+	for _, t := range tags {
+		result = append(result, t.Raw)
+	}
+	return result
+}
+
+func visibilityFromInfo(info *photos.PhotoInfoResponse) string {
+	switch {
+	case info.Photo.Visibility.IsPublic:
+		return "public"
+	case info.Photo.Visibility.IsFriend:
+		return "friends"
+	case info.Photo.Visibility.IsFamily:
+		return "family"
+	default:
+		return "private"
+	}
+}
+
+// photoSidecar is written as <photoid>.json next to each downloaded
+// original, recording the metadata rodeo pulled it with.
+type photoSidecar struct {
+	PhotoId     string   `json:"photo_id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	Visibility  string   `json:"visibility"`
+	Albums      []string `json:"albums"`
+	UploadDate  string   `json:"upload_date"`
+}
+
+func writeSidecar(dir string, p remotePhoto) error {
+	sidecar := photoSidecar{
+		PhotoId:     p.Id,
+		Title:       p.Title,
+		Description: p.Description,
+		Tags:        p.Tags,
+		Visibility:  p.Visibility,
+		Albums:      p.Albums,
+		UploadDate:  p.UploadDate.Format(time.RFC3339),
+	}
+
+	data, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, p.Id+".json")
+	return ioutil.WriteFile(path, data, 0664)
+}
+
+// downloadOriginal downloads a photo's original to dir, returning the path
+// it was written to, its SHA-256 and its size.
+func downloadOriginal(dir string, p remotePhoto) (string, string, int64, error) {
+	if p.OriginalURL == "" {
+		return "", "", 0, fmt.Errorf("no original URL available for %s", p.Id)
+	}
+
+	ext := filepath.Ext(p.OriginalURL)
+	destPath := filepath.Join(dir, p.Id+ext)
+
+	resp, err := http.Get(p.OriginalURL)
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", 0, fmt.Errorf("downloading %s: HTTP %d", p.Id, resp.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	if err := ioutil.WriteFile(destPath, data, 0664); err != nil {
+		return "", "", 0, err
+	}
+
+	sha, err := uploaddb.HashFile(destPath)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	return destPath, sha, int64(len(data)), nil
+}
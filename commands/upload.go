@@ -13,22 +13,39 @@ image to Flickr.
 package commands
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	. "github.com/akrabat/rodeo/internal"
+	"github.com/akrabat/rodeo/internal/publish"
+	_ "github.com/akrabat/rodeo/internal/publish/dryrun"
+	_ "github.com/akrabat/rodeo/internal/publish/flickr"
+	"github.com/akrabat/rodeo/internal/uploaddb"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"gopkg.in/masci/flickr.v2"
-	"gopkg.in/masci/flickr.v2/photos"
-	"gopkg.in/masci/flickr.v2/photosets"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
-const uploadedListBaseFilename = "rodeo-uploaded-files.json"
+// defaultUploadBackend is used when upload.backend is not set in config.
+const defaultUploadBackend = "flickr"
+
+// legacyUploadedListBaseFilename is the old JSON registry's filename. It is
+// only consulted to migrate existing installs into the uploaddb.
+const legacyUploadedListBaseFilename = "rodeo-uploaded-files.json"
+
+const uploadDBBaseFilename = "uploads.db"
+
+// defaultUploadWorkers is used when neither --jobs nor upload.workers is set.
+const defaultUploadWorkers = 4
+
+// numReaderWorkers sizes the pool that reads exif data and evaluates rules.
+// This is cheap, local work, so a small fixed pool is enough to keep the
+// (network-bound) uploader pool fed.
+const numReaderWorkers = 2
 
 func init() {
 	rootCmd.AddCommand(uploadCmd)
@@ -36,6 +53,7 @@ func init() {
 	// Register --force
 	uploadCmd.Flags().BoolP("force", "f", false, "Force upload of file even if already uploaded")
 	uploadCmd.Flags().BoolP("dry-run", "n", false, "Show what would have been uploaded")
+	uploadCmd.Flags().IntP("jobs", "j", 0, "Number of concurrent uploads (defaults to upload.workers, then 4)")
 }
 
 // uploadCmd represents the upload command
@@ -44,10 +62,18 @@ var uploadCmd = &cobra.Command{
 	Short: "Upload images to Flickr",
 	Long: `Upload images to Flickr
 
-- sets the date uploaded to the creation time of the image so that 
+- sets the date uploaded to the creation time of the image so that
   it appears in the photo stream at the right place.
 - sets tags as per exif keywords.
-- sets privacy if specific exif-keywords are set.
+- applies config.Rules: conditions match on exif keywords, and their actions
+  can set visibility, safety_level, content_type, license, add tags, add the
+  photo to albums, delete matched keywords, and fall back to templated
+  title/description when the image has none embedded (see
+  config.yaml.example for the rule syntax).
+
+Arguments may be files, directories (searched recursively) or glob patterns.
+Reading and uploading are pipelined across a pool of workers (see --jobs) so
+that uploading a large batch isn't bottlenecked on one file at a time.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 
@@ -62,19 +88,39 @@ var uploadCmd = &cobra.Command{
 			forceUpload = false
 		}
 
-		// Read the value of --force (if it is missing, the value is false)
+		// Read the value of --dry-run (if it is missing, the value is false)
 		dryRun, err := cmd.Flags().GetBool("dry-run")
 		if err != nil {
 			dryRun = false
 		}
 
-		var photoIds []string
-		for _, filename := range args {
-			photoId := uploadFile(filename, forceUpload, dryRun)
-			if photoId != "" {
-				photoIds = append(photoIds, photoId)
-			}
+		jobs, err := cmd.Flags().GetInt("jobs")
+		if err != nil || jobs <= 0 {
+			jobs = viper.GetInt("upload.workers")
+		}
+		if jobs <= 0 {
+			jobs = defaultUploadWorkers
+		}
+
+		filenames, err := expandFileArgs(args)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(2)
 		}
+		if len(filenames) == 0 {
+			fmt.Println("Error: No files matched.")
+			os.Exit(2)
+		}
+
+		backend := viper.GetString("upload.backend")
+		if backend == "" {
+			backend = defaultUploadBackend
+		}
+		if dryRun {
+			backend = "dryrun"
+		}
+
+		photoIds := runUploadPipeline(filenames, jobs, forceUpload, dryRun, backend)
 
 		fmt.Println("All Done")
 		fmt.Printf("View: http://www.flickr.com/photos/%s'\n", viper.GetString("flickr.username"))
@@ -85,19 +131,91 @@ var uploadCmd = &cobra.Command{
 	},
 }
 
-func uploadFile(filename string, forceUpload bool, dryRun bool) string {
-	fmt.Println("Processing " + filename)
+// expandFileArgs turns the command-line arguments into a flat list of
+// filenames, recursively expanding any directory arguments and resolving
+// glob patterns.
+func expandFileArgs(args []string) ([]string, error) {
+	var filenames []string
 
-	config := GetConfig()
+	for _, arg := range args {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", arg, err)
+		}
+		if len(matches) == 0 {
+			matches = []string{arg}
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+
+			if !info.IsDir() {
+				filenames = append(filenames, match)
+				continue
+			}
 
-	apiKey := config.Flickr.ApiKey
-	apiSecret := config.Flickr.ApiSecret
-	oauthToken := config.Flickr.OauthToken
-	oauthTokenSecret := config.Flickr.OauthSecret
-	if apiKey == "" || apiSecret == "" || oauthToken == "" || oauthTokenSecret == "" {
-		fmt.Println("Unable to continue. Please run the 'rodeo authenticate' command first")
+			err = filepath.Walk(match, func(path string, fi os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !fi.IsDir() {
+					filenames = append(filenames, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
+	return filenames, nil
+}
+
+// uploadJob is the unit of work handed from the reader pool to the uploader
+// pool once exif data has been read and rules have been evaluated for a
+// file.
+type uploadJob struct {
+	filename         string
+	info             *ImageInfo
+	keywordsToAdd    []string
+	keywordsToRemove []string
+	albumsToAddTo    []Album
+	resolved         ResolvedAction
+	warnings         []string
+}
+
+// uploadOutcome is reported by the reader and uploader pools once a file has
+// been dealt with, whether it was uploaded, skipped, or failed. warnings
+// carries any non-fatal messages collected while handling the file, so that
+// the single consumer of outcomeCh can print them without racing the
+// progress line.
+type uploadOutcome struct {
+	filename string
+	photoId  string
+	err      error
+	warnings []string
+}
+
+// recordRequest is sent to the recorder goroutine, the only goroutine
+// that records new uploads in the upload registry.
+type recordRequest struct {
+	filename string
+	photoId  string
+	title    string
+	tags     []string
+}
+
+// runUploadPipeline wires up the producer, reader pool, uploader pool and
+// recorder stages, blocks until every file has been dealt with, and prints a
+// live progress line followed by a summary of any failures. It returns the
+// ids of the photos that were uploaded.
+func runUploadPipeline(filenames []string, jobs int, forceUpload bool, dryRun bool, backend string) []string {
+	config := GetConfig()
+
 	exiftool := config.Cmd.Exiftool
 	if exiftool == "" {
 		fmt.Println("Error: cmd.exiftool needs to be configured.")
@@ -105,297 +223,522 @@ func uploadFile(filename string, forceUpload bool, dryRun bool) string {
 		os.Exit(2)
 	}
 
-	// Has this image been uploaded before?
-	if uploadedPhotoId := getUploadedPhotoId(filename, config.Upload.StoreUploadListInImageDir); uploadedPhotoId != "" {
-		fmt.Print("This image has already been uploaded to Flickr.")
-		if forceUpload == true {
-			fmt.Println(" Forcing upload.")
-		} else {
-			fmt.Printf("\nView this photo: http://www.flickr.com/photos/%s/%s\n", config.Flickr.Username, uploadedPhotoId)
-			fmt.Println("")
-			return ""
-		}
-	}
-
-	info, err := GetImageInfo(filename, exiftool)
+	publisher, err := publish.Open(backend, config)
 	if err != nil {
-		return ""
+		fmt.Println("Error:", err)
+		os.Exit(2)
 	}
 
-	// process rules
-	var keywordsToRemove []string
-	var keywordsToAdd []string
-	var albumsToAddTo []Album
+	ctx := context.Background()
 
-	if config.Rules != nil {
-		for _, rule := range config.Rules {
-			excludesAll := rule.Condition.ExcludesAll
-			excludesAny := rule.Condition.ExcludesAny
-			includesAll := rule.Condition.IncludesAll
-			includesAny := rule.Condition.IncludesAny
+	dbs := newUploadDBSet()
+	defer dbs.closeAll()
 
-			var intersection []string // applicable keywords from the condition
+	albums := newAlbumResolver()
 
-			// If the list of keywords for this image has all of `excludesAll`, then the rule is ignored
-			if len(excludesAll) > 0 {
-				intersection = Intersection(info.Keywords, excludesAll)
-				if len(intersection) == len(excludesAll) {
-					// Every `excludesAll` keyword is in info.Keywords, so this rule does not apply
-					//fmt.Println("Excluding due to `excludesAll`")
-					continue
-				}
-				//fmt.Println("`excludesAll` condition does not apply")
-			}
+	total := len(filenames)
+	fileCh := make(chan string, total)
+	jobCh := make(chan uploadJob, jobs*2)
+	outcomeCh := make(chan uploadOutcome, jobs)
+	recordCh := make(chan recordRequest)
+	recordDone := make(chan struct{})
 
-			// If the list of keywords for this image has any from `excludesAny`, then the rule is ignored
-			if len(excludesAny) > 0 {
-				intersection = Intersection(info.Keywords, excludesAny)
-				if len(intersection) > 0 {
-					// At least one `excludesAny` keyword is in info.Keywords, so this rule does not apply
-					//fmt.Println("Excluding due to `excludesAny`")
+	// Producer: feed every expanded filename onto fileCh.
+	go func() {
+		defer close(fileCh)
+		for _, filename := range filenames {
+			fileCh <- filename
+		}
+	}()
+
+	// Recorder: the only goroutine that records new uploads in the registry,
+	// so concurrent uploaders can't race on it.
+	go func() {
+		defer close(recordDone)
+		for req := range recordCh {
+			recordUpload(dbs, req, config.Upload.StoreUploadListInImageDir)
+		}
+	}()
+
+	// Reader pool: resolve exif data and evaluate rules for each file.
+	var readerWg sync.WaitGroup
+	for i := 0; i < numReaderWorkers; i++ {
+		readerWg.Add(1)
+		go func() {
+			defer readerWg.Done()
+			for filename := range fileCh {
+				job, outcome := prepareUploadJob(dbs, filename, exiftool, config, forceUpload)
+				if outcome != nil {
+					outcomeCh <- *outcome
 					continue
 				}
-				//fmt.Println("`excludesAny` condition does not apply")
+				jobCh <- *job
 			}
+		}()
+	}
 
-			processRules := false
-			if len(includesAll) > 0 {
-				//  info.Keywords must contain all keywords in `includesAll`
-				intersection = Intersection(info.Keywords, includesAll)
-				if len(intersection) != len(includesAll) {
-					// All `includesAll` keywords do not exist, so this rule does not apply
-					//fmt.Println("Excluding due to `includesAll`")
-					continue
-				}
-				//fmt.Println("`includesAll` condition is met")
-				processRules = true
-			} else if len(includesAny) > 0 {
-				//  info.Keywords must contain all keywords in `includesAny`
-				intersection = Intersection(info.Keywords, includesAny)
-				if len(intersection) == 0 {
-					// There are no `includesAny` keywords in info.Keywords, so this rule does not apply
-					//fmt.Println("Excluding due to `includesAny`")
-					continue
-				}
-				//fmt.Println("`includesAny` condition is met")
-				processRules = true
+	// Uploader pool: the only stage that talks to Flickr.
+	var uploaderWg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		uploaderWg.Add(1)
+		go func() {
+			defer uploaderWg.Done()
+			for job := range jobCh {
+				outcomeCh <- uploadPreparedJob(ctx, publisher, job, exiftool, config, recordCh, dryRun, albums)
 			}
+		}()
+	}
 
-			if processRules {
-				//fmt.Println("Will process rules")
-				//fmt.Printf("Applicable keywords: %s\n", strings.Join(intersection, ", "))
-				if rule.Action.Delete {
-					keywordsToRemove = append(keywordsToRemove, intersection...)
-				}
-				if len(rule.Action.Albums) > 0 {
-					for _, album := range rule.Action.Albums {
-						albumsToAddTo = append(albumsToAddTo, album)
-					}
-				}
+	go func() {
+		readerWg.Wait()
+		close(jobCh)
+	}()
+
+	go func() {
+		uploaderWg.Wait()
+		close(outcomeCh)
+	}()
+
+	var photoIds []string
+	var failures []uploadOutcome
+	done := 0
+	for outcome := range outcomeCh {
+		done++
+		fmt.Printf("\r\x1b[K[%d/%d] %s", done, total, outcome.filename)
+		if len(outcome.warnings) > 0 {
+			fmt.Println()
+			for _, w := range outcome.warnings {
+				fmt.Println("Warning:", w)
 			}
 		}
+		if outcome.err != nil {
+			failures = append(failures, outcome)
+		} else if outcome.photoId != "" {
+			photoIds = append(photoIds, outcome.photoId)
+		}
+	}
+	fmt.Println()
+
+	close(recordCh)
+	<-recordDone
+
+	if len(failures) > 0 {
+		fmt.Printf("\n%d of %d files failed to upload:\n", len(failures), total)
+		for _, f := range failures {
+			fmt.Printf("  - %s: %v\n", f.filename, f.err)
+		}
 	}
 
-	// Set the keywords to be added to the Flickr photo record
-	if len(keywordsToRemove) > 0 {
-		difference := Difference(info.Keywords, keywordsToRemove)
-		keywordsToAdd = difference
+	return photoIds
+}
+
+// prepareUploadJob resolves exif data and evaluates the rules for a single
+// file. It returns either a job to hand to the uploader pool, or a terminal
+// outcome (already uploaded, or unreadable) that skips uploading entirely.
+func prepareUploadJob(dbs *uploadDBSet, filename string, exiftool string, config *Config, forceUpload bool) (*uploadJob, *uploadOutcome) {
+	// Has this image been uploaded before?
+	if uploadedPhotoId := getUploadedPhotoId(dbs, filename, config.Upload.StoreUploadListInImageDir); uploadedPhotoId != "" && !forceUpload {
+		return nil, &uploadOutcome{filename: filename}
+	}
+
+	info, err := GetImageInfo(filename, exiftool)
+	if err != nil {
+		return nil, &uploadOutcome{filename: filename, err: err}
+	}
+
+	var warnings []string
+	sidecar, err := LoadSidecar(filename)
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("couldn't read sidecar for %s: %v", filename, err))
+	}
+	applySidecarToImageInfo(info, sidecar)
+
+	keywordsToAdd, resolved := evaluateRules(info, config, sidecar)
+
+	return &uploadJob{
+		filename:         filename,
+		info:             info,
+		keywordsToAdd:    keywordsToAdd,
+		keywordsToRemove: resolved.KeywordsToRemove,
+		albumsToAddTo:    resolved.Albums,
+		resolved:         resolved,
+		warnings:         warnings,
+	}, nil
+}
+
+// applySidecarToImageInfo overrides info's title/description with the
+// sidecar's, when the sidecar sets them. This lets a sidecar pin a title or
+// description without touching the file's exif data.
+func applySidecarToImageInfo(info *ImageInfo, sidecar *Sidecar) {
+	if sidecar == nil {
+		return
+	}
+	if sidecar.Title != "" {
+		info.Title = sidecar.Title
+	}
+	if sidecar.Description != "" {
+		info.Description = sidecar.Description
+	}
+}
+
+// evaluateRules merges the sidecar's add_tags into info's keywords *before*
+// resolving rules, so a sidecar-added tag can trigger a config rule's
+// includes_any/includes_all just as an exif keyword would, then applies the
+// rest of the sidecar override on top (sidecars always win). It returns the
+// keywords to send to Flickr alongside the resolved action (visibility,
+// albums, title/description templates, etc).
+func evaluateRules(info *ImageInfo, config *Config, sidecar *Sidecar) (keywordsToAdd []string, resolved ResolvedAction) {
+	keywords := info.Keywords
+	if sidecar != nil {
+		keywords = append(append([]string{}, keywords...), Difference(sidecar.AddTags, keywords)...)
+	}
+
+	resolved = ResolveRules(config.Rules, keywords)
+
+	if sidecar != nil {
+		resolved.KeywordsToRemove = append(resolved.KeywordsToRemove, sidecar.RemoveTags...)
+		resolved = ApplyAction(resolved, Action{
+			Albums:      sidecar.Albums,
+			Visibility:  sidecar.Visibility,
+			SafetyLevel: sidecar.SafetyLevel,
+			ContentType: sidecar.ContentType,
+			Hidden:      sidecar.Hidden,
+			License:     sidecar.License,
+		})
+	}
+
+	if len(resolved.KeywordsToRemove) > 0 {
+		keywordsToAdd = Difference(keywords, resolved.KeywordsToRemove)
 	} else {
-		keywordsToAdd = info.Keywords
+		keywordsToAdd = keywords
 	}
+	keywordsToAdd = append(keywordsToAdd, Difference(resolved.AddTags, keywordsToAdd)...)
 
-	// output what we are going to do
-	if len(keywordsToRemove) > 0 || len(albumsToAddTo) > 0 {
-		fmt.Printf("Actions:\n")
-		if len(keywordsToRemove) > 0 {
-			fmt.Printf("  - keywords to remove: %s\n", strings.Join(keywordsToRemove, ", "))
-		}
-		if len(albumsToAddTo) > 0 {
-			strs := make([]string, len(albumsToAddTo))
-			for i, a := range albumsToAddTo {
-				strs[i] = a.Name
-			}
-			fmt.Printf("  - albums to add to: %s\n", strings.Join(strs, ", "))
+	return keywordsToAdd, resolved
+}
+
+// resolveTitle uses the image's embedded title if it has one, falling back
+// to the rule-supplied title template and then the filename.
+func resolveTitle(info *ImageInfo, resolved ResolvedAction, filename string) string {
+	if title := strings.Trim(info.Title, " "); title != "" {
+		return title
+	}
+
+	if resolved.TitleTemplate != "" {
+		if title, err := RenderTemplate(resolved.TitleTemplate, info); err == nil {
+			return title
 		}
-		fmt.Printf("\n")
 	}
 
-	// All ready to process now
-	if dryRun {
-		fmt.Println("Would upload photo to Flickr")
-		return ""
+	// no title - use filename (without extension)
+	title := filepath.Base(filename)
+	return strings.TrimSuffix(title, filepath.Ext(filename))
+}
+
+// resolveDescription uses the image's embedded description if it has one,
+// falling back to the rule-supplied description template.
+func resolveDescription(info *ImageInfo, resolved ResolvedAction) string {
+	if info.Description != "" {
+		return info.Description
 	}
 
-	if len(keywordsToRemove) > 0 && exiftool != "" {
+	if resolved.DescriptionTemplate != "" {
+		if description, err := RenderTemplate(resolved.DescriptionTemplate, info); err == nil {
+			return description
+		}
+	}
+
+	return ""
+}
+
+// uploadPreparedJob performs the upload of a single prepared job: stripping
+// removed keywords from the file on disk, uploading via publisher, setting
+// the date posted and album membership, and asking the recorder to persist
+// the result. It never touches the uploaded-list file directly. When dryRun
+// is set, the file on disk is left untouched entirely: nothing should be
+// uploaded, so nothing should be mutated either.
+func uploadPreparedJob(ctx context.Context, publisher publish.Publisher, job uploadJob, exiftool string, config *Config, recordCh chan<- recordRequest, dryRun bool, albums *albumResolver) uploadOutcome {
+	filename := job.filename
+	info := job.info
+	warnings := append([]string(nil), job.warnings...)
+
+	if !dryRun && len(job.keywordsToRemove) > 0 && exiftool != "" {
 		// Format of command: exiftool -overwrite_original -keywords-=one -keywords-=two FILENAME
 		var parameters []string
 		parameters = append(parameters, "-overwrite_original")
-		for _, k := range keywordsToRemove {
+		for _, k := range job.keywordsToRemove {
 			parameters = append(parameters, fmt.Sprintf("-keywords-=%s", k))
 			parameters = append(parameters, fmt.Sprintf("-subject-=%s", k))
 		}
 		parameters = append(parameters, filename)
-		//fmt.Println("Removing keywords from photo")
 		cmd := exec.Command(exiftool, parameters...)
 		cmd.Dir = filepath.Dir(filename)
 		if err := cmd.Run(); err != nil {
-			fmt.Println("Error: ", err)
+			return uploadOutcome{filename: filename, err: fmt.Errorf("removing keywords: %w", err), warnings: warnings}
 		}
 	}
 
-	// Upload file to Flickr
-	fmt.Println("Uploading photo to Flickr")
+	title := resolveTitle(info, job.resolved, filename)
+	description := resolveDescription(info, job.resolved)
 
-	client := flickr.NewFlickrClient(apiKey, apiSecret)
-	client.OAuthToken = oauthToken
-	client.OAuthTokenSecret = oauthTokenSecret
-
-	title := strings.Trim(info.Title, " ")
-	if title == "" {
-		// no title - use filename (without extension)
-		title = filepath.Base(filename)
-		title = strings.TrimSuffix(title, filepath.Ext(filename))
+	meta := publish.Metadata{
+		Title:       title,
+		Description: description,
+		Tags:        job.keywordsToAdd,
+		Visibility:  job.resolved.Visibility,
+		SafetyLevel: job.resolved.SafetyLevel,
+		ContentType: job.resolved.ContentType,
+		Hidden:      job.resolved.Hidden != nil && *job.resolved.Hidden,
+		License:     job.resolved.License,
 	}
 
-	// Upload photo
-
-	// quote keywords for Flickr's tags
-	tags := make([]string, len(keywordsToAdd))
-	for i, kw := range keywordsToAdd {
-		tags[i] = fmt.Sprintf("\"%s\"", kw)
+	photo, err := publisher.Upload(ctx, filename, meta)
+	if err != nil {
+		return uploadOutcome{filename: filename, err: err, warnings: warnings}
 	}
+	photoId := photo.ID
 
-	params := flickr.UploadParams{
-		Title:       title,
-		Tags:        tags,
-		IsPublic:    true,
-		IsFamily:    true,
-		IsFriend:    true,
-		ContentType: 1, // photo
-		Hidden:      1, // not hidden
-		SafetyLevel: 1, // safe
-	}
-	if info.Description != "" {
-		params.Description = info.Description
+	// A backend such as dryrun returns an empty PhotoRef to signal that
+	// nothing was actually published, so there's nothing to record either.
+	if photoId == "" {
+		return uploadOutcome{filename: filename, warnings: warnings}
 	}
 
-	response, err := flickr.UploadFile(client, filename, &params)
-	if err != nil {
-		fmt.Println(err)
-		return ""
+	recordCh <- recordRequest{filename: filename, photoId: photoId, title: title, tags: job.keywordsToAdd}
+
+	if config.Upload.StoreUploadListInImageDir {
+		albumNames := make([]string, len(job.albumsToAddTo))
+		for i, album := range job.albumsToAddTo {
+			albumNames[i] = album.String()
+		}
+
+		audit := AuditSidecar{
+			PhotoId:     photoId,
+			Title:       title,
+			Description: description,
+			Tags:        job.keywordsToAdd,
+			Visibility:  job.resolved.Visibility,
+			SafetyLevel: job.resolved.SafetyLevel,
+			ContentType: job.resolved.ContentType,
+			Hidden:      job.resolved.Hidden != nil && *job.resolved.Hidden,
+			License:     job.resolved.License,
+			Albums:      albumNames,
+		}
+		if err := WriteAuditSidecar(filename, audit); err != nil {
+			warnings = append(warnings, fmt.Sprintf("couldn't write audit sidecar for %s: %v", filename, err))
+		}
 	}
-	photoId := response.ID
-	recordUpload(filename, photoId, config.Upload.StoreUploadListInImageDir)
-	fmt.Printf("Uploaded photo '%s'\n", title)
 
 	// set date posted to the date that the photo was taken so that it's in the right place
-	// in the Flickr photo stream
-	setDatePosted := config.Upload.SetDatePosted
-	if setDatePosted == true && info.Date != nil {
-		datePosted := fmt.Sprintf("%d", info.Date.Unix())
-		respSetDate, err := photos.SetDates(client, photoId, datePosted, "")
-		if err != nil {
-			fmt.Printf("Failed update photo %v's date posted: %v\n%v\n", photoId, err, respSetDate.ErrorMsg())
+	// in the destination's photo stream
+	if config.Upload.SetDatePosted && info.Date != nil {
+		if err := publisher.SetDatePosted(ctx, photo, *info.Date); err != nil {
+			warnings = append(warnings, fmt.Sprintf("failed to update photo %v's date posted: %v", photoId, err))
 		}
 	}
 
-	if len(albumsToAddTo) > 0 {
-		// assign photo to each photoset in the list
-		for _, album := range albumsToAddTo {
-			respAdd, err := photosets.AddPhoto(client, album.Id, photoId)
+	// assign photo to each album in the list
+	for _, album := range job.albumsToAddTo {
+		albumRef := publish.AlbumRef{ID: album.Id, Name: album.Name}
+		if albumRef.ID == "" {
+			resolvedRef, addedPhoto, err := albums.resolve(ctx, publisher, photo, album.String())
 			if err != nil {
-				//noinspection GoNilness
-				fmt.Println("Failed adding photo to the set: "+album.String(), err, respAdd.ErrorMsg())
-			} else {
-				fmt.Println("Added photo", photoId, "to set", album.String())
+				warnings = append(warnings, fmt.Sprintf("failed to resolve album %s: %v", album.String(), err))
+				continue
+			}
+			if addedPhoto {
+				continue
 			}
+			albumRef = resolvedRef
+		}
+
+		if err := publisher.AddToAlbum(ctx, photo, albumRef); err != nil {
+			warnings = append(warnings, fmt.Sprintf("failed adding photo to album %s: %v", album.String(), err))
 		}
 	}
 
-	fmt.Printf("View this photo: http://www.flickr.com/photos/%s/%s\n", config.Flickr.Username, photoId)
-	fmt.Println("")
-	return photoId
+	return uploadOutcome{filename: filename, photoId: photoId, warnings: warnings}
+}
+
+// albumResolver serializes "find or create" album resolution across the
+// uploader pool. Without it, two photos destined for the same
+// not-yet-existing album in the same batch could both observe "no such
+// album" from EnsureAlbum and each create it, producing duplicates.
+type albumResolver struct {
+	mu    sync.Mutex
+	known map[string]publish.AlbumRef
 }
 
-func getUploadedListFilename(imageFilename string, storeUploadListInImageDirectory bool) string {
-	var directory string
+func newAlbumResolver() *albumResolver {
+	return &albumResolver{known: make(map[string]publish.AlbumRef)}
+}
 
-	if storeUploadListInImageDirectory {
-		// File is stored in directory where image is and is hidden via a leading `.` on the imageFilename
-		directory = filepath.Dir(imageFilename)
-		return directory + "/." + uploadedListBaseFilename;
+// resolve returns the AlbumRef for albumName, creating it via publisher if
+// necessary. The whole find-or-create sequence runs under resolver's lock,
+// so a second call for the same name blocks until the first has either
+// found or created it, and the result is cached so later calls skip the
+// lookup entirely. photo is used as the new album's primary photo if it has
+// to be created; addedPhoto reports whether that creation already added
+// photo to the album, so the caller doesn't need to call AddToAlbum again.
+func (r *albumResolver) resolve(ctx context.Context, publisher publish.Publisher, photo publish.PhotoRef, albumName string) (ref publish.AlbumRef, addedPhoto bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ref, ok := r.known[albumName]; ok {
+		return ref, false, nil
 	}
 
-	// Storing to the config directory
-	return ConfigDir() + "/" + uploadedListBaseFilename;
-}
+	ref, err = publisher.EnsureAlbum(ctx, albumName)
+	if err != nil {
+		return publish.AlbumRef{}, false, err
+	}
 
-// Has this file been uploaded to Flickr?
-// Check the `.rodeo-uploaded-files` file that resides in the same directory as `filename`
-func getUploadedPhotoId(filename string, storeUploadedListInImageDirectory bool) string {
-	uploadedListFilename := getUploadedListFilename(filename, storeUploadedListInImageDirectory)
-	filenames := readUploadedListFile(uploadedListFilename)
+	if ref.ID == "" {
+		// No such album yet: AddToAlbum creates one (with photo as its
+		// primary) when given a ref with no ID. Look it up again afterwards
+		// so later resolves for albumName get its real ID instead of
+		// creating a second album.
+		if err := publisher.AddToAlbum(ctx, photo, ref); err != nil {
+			return publish.AlbumRef{}, false, err
+		}
+		ref, err = publisher.EnsureAlbum(ctx, albumName)
+		if err != nil {
+			return publish.AlbumRef{}, false, err
+		}
+		r.known[albumName] = ref
+		return ref, true, nil
+	}
+
+	r.known[albumName] = ref
+	return ref, false, nil
+}
 
-	// Is imageFilename a key in the map?
-	imageFilename := filepath.Base(filename)
-	if photoId, ok := filenames[imageFilename]; ok {
-		// imageFilename exists, return its associated photoId
-		return photoId
+// getUploadDBPath returns the uploaddb database that tracks imageFilename,
+// mirroring the layout the old JSON registry used: either a hidden file
+// next to the image, or a single shared database in the config directory.
+func getUploadDBPath(imageFilename string, storeUploadDBInImageDirectory bool) string {
+	if storeUploadDBInImageDirectory {
+		directory := filepath.Dir(imageFilename)
+		return directory + "/." + uploadDBBaseFilename
 	}
 
-	return ""
+	return ConfigDir() + "/" + uploadDBBaseFilename
 }
 
-// Record the filename of the image uploaded into the uploaded list
-func recordUpload(filename string, photoId string, storeUploadedListInImageDirectory bool) {
-	imageFilename := filepath.Base(filename)
-	uploadedListFilename := getUploadedListFilename(filename, storeUploadedListInImageDirectory)
-	filenames := readUploadedListFile(uploadedListFilename)
+// uploadDBSet lazily opens and caches one uploaddb.DB per database path, so
+// that every file sharing a directory (or the single config-dir database)
+// reuses the same connection instead of reopening it per file.
+type uploadDBSet struct {
+	mu  sync.Mutex
+	dbs map[string]*uploaddb.DB
+}
 
-	// If the imageFilename is already recorded, then there's nothing to do
-	if _, ok := filenames[imageFilename]; ok {
-		return
+func newUploadDBSet() *uploadDBSet {
+	return &uploadDBSet{dbs: make(map[string]*uploaddb.DB)}
+}
+
+// get opens (if necessary) the database for imageFilename, migrating the
+// legacy JSON registry alongside it the first time it's opened. The legacy
+// file is renamed once it's been migrated, so the import is a one-shot: it
+// doesn't re-run on every subsequent invocation.
+func (s *uploadDBSet) get(imageFilename string, storeInImageDir bool) (*uploaddb.DB, error) {
+	path := getUploadDBPath(imageFilename, storeInImageDir)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if db, ok := s.dbs[path]; ok {
+		return db, nil
 	}
 
-	// Filename not in list, so append to list and save
-	filenames[imageFilename] = photoId
-	writeUploadedListFile(filenames, uploadedListFilename)
+	db, err := uploaddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	legacyPath := getLegacyUploadedListFilename(imageFilename, storeInImageDir)
+	if _, err := os.Stat(legacyPath); err == nil {
+		if _, err := db.MigrateJSONFile(legacyPath, filepath.Dir(legacyPath)); err != nil {
+			fmt.Printf("Warning: failed to migrate %s: %v\n", legacyPath, err)
+		} else if err := os.Rename(legacyPath, legacyPath+".migrated"); err != nil {
+			fmt.Printf("Warning: failed to mark %s as migrated: %v\n", legacyPath, err)
+		}
+	}
+
+	s.dbs[path] = db
+	return db, nil
 }
 
-// Read the uploaded list from the `uploadedListFilename` and convert to a map from the JSON
-func readUploadedListFile(uploadedListFilename string) map[string]string {
-	filenames := make(map[string]string)
+func (s *uploadDBSet) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, db := range s.dbs {
+		db.Close()
+	}
+}
 
-	// Does the file exist?
-	if _, err := os.Stat(uploadedListFilename); err == nil || os.IsExist(err) {
-		// File exists - therefore read it
-		data, err := ioutil.ReadFile(uploadedListFilename)
-		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return filenames
-		}
+func getLegacyUploadedListFilename(imageFilename string, storeUploadListInImageDirectory bool) string {
+	if storeUploadListInImageDirectory {
+		return filepath.Dir(imageFilename) + "/." + legacyUploadedListBaseFilename
+	}
 
-		err = json.Unmarshal(data, &filenames)
-		if err != nil {
-			fmt.Println("error:", err)
-		}
+	return ConfigDir() + "/" + legacyUploadedListBaseFilename
+}
+
+// Has this file been uploaded to Flickr before? Looked up by the SHA-256 of
+// its contents, so renaming or moving the file doesn't break dedup.
+func getUploadedPhotoId(dbs *uploadDBSet, filename string, storeUploadDBInImageDirectory bool) string {
+	db, err := dbs.get(filename, storeUploadDBInImageDirectory)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return ""
 	}
 
-	return filenames
+	upload, err := db.FindByFile(filename)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return ""
+	}
+	if upload == nil {
+		return ""
+	}
+
+	return upload.PhotoID
 }
 
-// Write the uploaded list to the `uploadedListFilename` in JSON format
-func writeUploadedListFile(filenames map[string]string, uploadedListFilename string) {
-	// Convert to JSON
-	data, err := json.MarshalIndent(filenames, "", "  ")
+// Record the upload of filename into the registry.
+// Only the recorder goroutine started by runUploadPipeline calls this, so
+// writes to a given database can never race with another upload.
+func recordUpload(dbs *uploadDBSet, req recordRequest, storeUploadDBInImageDirectory bool) {
+	db, err := dbs.get(req.filename, storeUploadDBInImageDirectory)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	sha, err := uploaddb.HashFile(req.filename)
 	if err != nil {
-		fmt.Println("error:", err)
+		fmt.Printf("Error: %v\n", err)
+		return
 	}
 
-	// Write to disk
-	err = ioutil.WriteFile(uploadedListFilename, data, 0664)
+	fi, err := os.Stat(req.filename)
 	if err != nil {
-		fmt.Printf("Error: Unable to write %s: %v", filepath.Base(uploadedListFilename), err)
+		fmt.Printf("Error: %v\n", err)
 		return
 	}
+
+	err = db.Record(uploaddb.Upload{
+		SHA256:     sha,
+		PhotoID:    req.photoId,
+		Filename:   filepath.Base(req.filename),
+		Size:       fi.Size(),
+		Mtime:      fi.ModTime().Unix(),
+		UploadedAt: time.Now().Unix(),
+		Title:      req.title,
+		Tags:       strings.Join(req.tags, ","),
+	})
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
 }
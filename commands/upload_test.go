@@ -0,0 +1,156 @@
+/*
+Copyright © 2020 Rob Allen <rob@akrabat.com>
+
+Use of this source code is governed by the MIT
+license that can be found in the LICENSE file or at
+https://akrabat.com/license/mit.
+*/
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/akrabat/rodeo/internal"
+	"github.com/akrabat/rodeo/internal/publish"
+	"github.com/akrabat/rodeo/internal/publish/fake"
+)
+
+func intPtr(i int) *int { return &i }
+
+// TestUploadPreparedJobEndToEnd exercises the rules engine and
+// uploadPreparedJob together, the way the real upload pipeline does: rules
+// are resolved against exif keywords, the result is built into an
+// uploadJob, and uploadPreparedJob maps it onto a fake.Publisher instead of
+// hitting Flickr. Unlike a hand-rolled ResolvedAction -> Metadata mapping,
+// this catches a regression in uploadPreparedJob itself.
+func TestUploadPreparedJobEndToEnd(t *testing.T) {
+	rules := []Rule{
+		{
+			Condition: Condition{IncludesAny: []string{"family"}},
+			Action: Action{
+				Visibility: "friends",
+				Albums:     []Album{{Name: "Family"}},
+			},
+		},
+		{
+			Condition: Condition{IncludesAny: []string{"2026"}},
+			Action: Action{
+				SafetyLevel: "safe",
+				License:     intPtr(4),
+				Albums:      []Album{{Name: "2026"}},
+			},
+		},
+	}
+
+	takenAt := time.Date(2026, time.January, 2, 0, 0, 0, 0, time.UTC)
+	info := &ImageInfo{
+		Title:    "Sunset",
+		Keywords: []string{"family", "2026"},
+		Date:     &takenAt,
+	}
+	config := &Config{Rules: rules, Upload: UploadConfig{SetDatePosted: true}}
+
+	keywordsToAdd, resolved := evaluateRules(info, config, nil)
+
+	job := uploadJob{
+		filename:      "/photos/sunset.jpg",
+		info:          info,
+		keywordsToAdd: keywordsToAdd,
+		albumsToAddTo: resolved.Albums,
+		resolved:      resolved,
+	}
+
+	publisher := fake.New()
+	recordCh := make(chan recordRequest, 1)
+
+	outcome := uploadPreparedJob(context.Background(), publisher, job, "", config, recordCh, false, newAlbumResolver())
+	if outcome.err != nil {
+		t.Fatalf("uploadPreparedJob() error = %v", outcome.err)
+	}
+
+	if len(publisher.Uploads) != 1 {
+		t.Fatalf("Uploads = %+v, want 1 upload", publisher.Uploads)
+	}
+	meta := publisher.Uploads[0].Meta
+	if meta.Visibility != "friends" || meta.SafetyLevel != "safe" {
+		t.Errorf("Uploads[0].Meta = %+v, want visibility=friends safety_level=safe", meta)
+	}
+	if meta.License == nil || *meta.License != 4 {
+		t.Errorf("Uploads[0].Meta.License = %v, want 4", meta.License)
+	}
+
+	req := <-recordCh
+	if req.photoId != outcome.photoId {
+		t.Errorf("recordRequest.photoId = %q, want %q", req.photoId, outcome.photoId)
+	}
+
+	if !publisher.DatesPosted[outcome.photoId].Equal(takenAt) {
+		t.Errorf("DatesPosted[%s] = %v, want %v", outcome.photoId, publisher.DatesPosted[outcome.photoId], takenAt)
+	}
+
+	for _, name := range []string{"Family", "2026"} {
+		album, ok := publisher.Albums[name]
+		if !ok {
+			t.Fatalf("Albums[%q] not recorded", name)
+		}
+		members := publisher.AlbumMembers[album.ID]
+		if len(members) != 1 || members[0] != outcome.photoId {
+			t.Errorf("AlbumMembers[%q] = %v, want [%s]", name, members, outcome.photoId)
+		}
+	}
+}
+
+// TestAlbumResolverConcurrentCreate drives two concurrent resolve calls for
+// the same not-yet-existing album name through a fake.Publisher, which
+// (like flickr.Publisher) creates a brand new album every time AddToAlbum
+// is called with an empty-ID ref. Without albumResolver's locking, both
+// calls would observe "no such album" from EnsureAlbum and each create one,
+// producing two albums instead of one.
+func TestAlbumResolverConcurrentCreate(t *testing.T) {
+	publisher := fake.New()
+	resolver := newAlbumResolver()
+
+	const photos = 2
+	refs := make([]publish.AlbumRef, photos)
+	var wg sync.WaitGroup
+	for i := 0; i < photos; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			photo := publish.PhotoRef{ID: fmt.Sprintf("photo-%d", i)}
+			ref, addedPhoto, err := resolver.resolve(context.Background(), publisher, photo, "NewAlbum")
+			if err != nil {
+				t.Errorf("resolve() error = %v", err)
+				return
+			}
+			// Like uploadPreparedJob, add the photo ourselves unless resolve
+			// already did so while creating the album.
+			if !addedPhoto {
+				if err := publisher.AddToAlbum(context.Background(), photo, ref); err != nil {
+					t.Errorf("AddToAlbum() error = %v", err)
+					return
+				}
+			}
+			refs[i] = ref
+		}(i)
+	}
+	wg.Wait()
+
+	if refs[0].ID == "" || refs[1].ID == "" || refs[0].ID != refs[1].ID {
+		t.Fatalf("resolve() returned different refs for concurrent calls on the same album name: %+v, %+v", refs[0], refs[1])
+	}
+
+	if len(publisher.Albums) != 1 {
+		t.Errorf("Albums = %+v, want exactly one album created", publisher.Albums)
+	}
+
+	members := publisher.AlbumMembers[refs[0].ID]
+	if len(members) != photos {
+		t.Errorf("AlbumMembers[%q] = %v, want %d members", refs[0].ID, members, photos)
+	}
+}
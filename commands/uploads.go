@@ -0,0 +1,119 @@
+/*
+Copyright © 2020 Rob Allen <rob@akrabat.com>
+
+Use of this source code is governed by the MIT
+license that can be found in the LICENSE file or at
+https://akrabat.com/license/mit.
+*/
+
+package commands
+
+import (
+	"fmt"
+	. "github.com/akrabat/rodeo/internal"
+	"github.com/akrabat/rodeo/internal/uploaddb"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+func init() {
+	rootCmd.AddCommand(uploadsCmd)
+	uploadsCmd.AddCommand(uploadsListCmd)
+	uploadsCmd.AddCommand(uploadsSearchCmd)
+	uploadsCmd.AddCommand(uploadsRemoveCmd)
+
+	uploadsCmd.PersistentFlags().String("dir", "", "Use the per-directory upload database in this directory (upload.store_upload_list_in_image_dir) instead of the shared one")
+}
+
+// uploadsCmd represents the uploads command
+var uploadsCmd = &cobra.Command{
+	Use:   "uploads",
+	Short: "Inspect the registry of files already uploaded to Flickr",
+}
+
+var uploadsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List every recorded upload",
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openUploadsDB(cmd)
+		defer db.Close()
+
+		uploads, err := db.List()
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(2)
+		}
+
+		printUploads(uploads)
+	},
+}
+
+var uploadsSearchCmd = &cobra.Command{
+	Use:   "search <query>",
+	Short: "Search recorded uploads by filename, title or tag",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openUploadsDB(cmd)
+		defer db.Close()
+
+		uploads, err := db.Search(args[0])
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(2)
+		}
+
+		printUploads(uploads)
+	},
+}
+
+var uploadsRemoveCmd = &cobra.Command{
+	Use:   "remove <sha256>",
+	Short: "Remove a recorded upload by its content hash, so it will be uploaded again",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		db := openUploadsDB(cmd)
+		defer db.Close()
+
+		if err := db.Remove(args[0]); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(2)
+		}
+
+		fmt.Println("Removed", args[0])
+	},
+}
+
+// openUploadsDB opens the uploads database to manage: the shared one in the
+// config directory, or, when --dir is given, the per-directory one used by
+// upload.store_upload_list_in_image_dir for images stored there.
+func openUploadsDB(cmd *cobra.Command) *uploaddb.DB {
+	dir, err := cmd.Flags().GetString("dir")
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(2)
+	}
+
+	path := ConfigDir() + "/" + uploadDBBaseFilename
+	if dir != "" {
+		path = filepath.Join(dir, "."+uploadDBBaseFilename)
+	}
+
+	db, err := uploaddb.Open(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(2)
+	}
+	return db
+}
+
+func printUploads(uploads []uploaddb.Upload) {
+	if len(uploads) == 0 {
+		fmt.Println("No uploads recorded.")
+		return
+	}
+
+	for _, u := range uploads {
+		fmt.Printf("%s  %-10s  %s\n", u.SHA256, u.PhotoID, u.Filename)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright © 2020 Rob Allen <rob@akrabat.com>
+
+Use of this source code is governed by the MIT
+license that can be found in the LICENSE file or at
+https://akrabat.com/license/mit.
+*/
+
+package internal
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+)
+
+// FlickrConfig holds the credentials obtained from `rodeo authenticate`.
+type FlickrConfig struct {
+	ApiKey      string `mapstructure:"api_key"`
+	ApiSecret   string `mapstructure:"api_secret"`
+	OauthToken  string `mapstructure:"oauth_token"`
+	OauthSecret string `mapstructure:"oauth_secret"`
+	Username    string `mapstructure:"username"`
+}
+
+// CmdConfig holds the paths to external commands that rodeo shells out to.
+type CmdConfig struct {
+	Exiftool string `mapstructure:"exiftool"`
+}
+
+// UploadConfig holds settings that control how `rodeo upload` behaves.
+type UploadConfig struct {
+	StoreUploadListInImageDir bool   `mapstructure:"store_upload_list_in_image_dir"`
+	SetDatePosted             bool   `mapstructure:"set_date_posted"`
+	Workers                   int    `mapstructure:"workers"`
+	Backend                   string `mapstructure:"backend"`
+}
+
+// PullConfig holds settings that control how `rodeo pull` behaves.
+type PullConfig struct {
+	Directory string `mapstructure:"directory"`
+}
+
+// Album identifies a Flickr photoset, either by id or by name.
+type Album struct {
+	Id   string `mapstructure:"id"`
+	Name string `mapstructure:"name"`
+}
+
+func (a Album) String() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	return a.Id
+}
+
+// Condition describes when a Rule applies, based on the image's exif
+// keywords.
+type Condition struct {
+	IncludesAll []string `mapstructure:"includes_all"`
+	IncludesAny []string `mapstructure:"includes_any"`
+	ExcludesAll []string `mapstructure:"excludes_all"`
+	ExcludesAny []string `mapstructure:"excludes_any"`
+}
+
+// Action describes what to do to a photo when its Rule's Condition is met.
+// Every field is optional; only the fields that are set are applied, and
+// when several matching rules set the same field, the later rule (in
+// config.Rules order) wins. Visibility, SafetyLevel, ContentType, License
+// and Title/Description are therefore "last write wins" between rules,
+// while Delete, Albums and AddTags accumulate across every matching rule.
+type Action struct {
+	// Delete removes the condition's matched keywords from the image's
+	// exif data and from the tags sent to Flickr.
+	Delete bool `mapstructure:"delete"`
+
+	// AddTags adds these tags to the photo regardless of its exif
+	// keywords.
+	AddTags []string `mapstructure:"add_tags"`
+
+	// Albums are the photosets that the photo should be added to.
+	Albums []Album `mapstructure:"albums"`
+
+	// Visibility is one of "public", "friends", "family" or "private".
+	Visibility string `mapstructure:"visibility"`
+
+	// SafetyLevel is one of "safe", "moderate" or "restricted".
+	SafetyLevel string `mapstructure:"safety_level"`
+
+	// ContentType is one of "photo", "screenshot" or "other".
+	ContentType string `mapstructure:"content_type"`
+
+	// Hidden controls whether the photo is hidden from global search.
+	Hidden *bool `mapstructure:"hidden"`
+
+	// License is a Flickr numeric license id, e.g. 4 for "Attribution
+	// License". See https://www.flickr.com/services/api/flickr.photos.licenses.getInfo.html
+	License *int `mapstructure:"license"`
+
+	// Title and Description are Go text/template strings rendered against
+	// the image's ImageInfo (Title, Description, Keywords, Date), e.g.
+	// `{{.Date.Format "Jan 2006"}}`. They are only used when the image has
+	// no embedded title/description.
+	Title       string `mapstructure:"title"`
+	Description string `mapstructure:"description"`
+}
+
+// Rule pairs a Condition with the Action to take when it matches.
+type Rule struct {
+	Condition Condition `mapstructure:"condition"`
+	Action    Action    `mapstructure:"action"`
+}
+
+// Config is rodeo's top-level configuration, read from $HOME/.config/rodeo/config.yaml
+// (or wherever viper's config file is found).
+type Config struct {
+	Flickr FlickrConfig `mapstructure:"flickr"`
+	Cmd    CmdConfig    `mapstructure:"cmd"`
+	Upload UploadConfig `mapstructure:"upload"`
+	Pull   PullConfig   `mapstructure:"pull"`
+	Rules  []Rule       `mapstructure:"rules"`
+}
+
+// GetConfig unmarshals viper's active configuration into a Config.
+func GetConfig() *Config {
+	var config Config
+	if err := viper.Unmarshal(&config); err != nil {
+		fmt.Println("Error: unable to parse config:", err)
+		os.Exit(2)
+	}
+	return &config
+}
+
+// ConfigDir returns the directory rodeo stores its own data in, separate
+// from the user's config file.
+func ConfigDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "."
+	}
+	return filepath.Join(home, ".config", "rodeo")
+}
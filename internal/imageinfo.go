@@ -0,0 +1,93 @@
+/*
+Copyright © 2020 Rob Allen <rob@akrabat.com>
+
+Use of this source code is governed by the MIT
+license that can be found in the LICENSE file or at
+https://akrabat.com/license/mit.
+*/
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// ImageInfo is the subset of an image's exif data that rodeo cares about.
+type ImageInfo struct {
+	Title       string
+	Description string
+	Keywords    []string
+	Date        *time.Time
+}
+
+// exiftoolOutput mirrors the fields rodeo reads out of `exiftool -j`.
+type exiftoolOutput struct {
+	Title            string      `json:"Title"`
+	Description      string      `json:"Description"`
+	Subject          interface{} `json:"Subject"`
+	Keywords         interface{} `json:"Keywords"`
+	DateTimeOriginal string      `json:"DateTimeOriginal"`
+}
+
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// GetImageInfo shells out to exiftool to read the title, description,
+// keywords and capture date embedded in filename.
+func GetImageInfo(filename string, exiftool string) (*ImageInfo, error) {
+	cmd := exec.Command(exiftool, "-j", "-Title", "-Description", "-Keywords", "-Subject", "-DateTimeOriginal", filename)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("reading exif data from %s: %w", filename, err)
+	}
+
+	var results []exiftoolOutput
+	if err := json.Unmarshal(output, &results); err != nil {
+		return nil, fmt.Errorf("parsing exif data from %s: %w", filename, err)
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("no exif data returned for %s", filename)
+	}
+	result := results[0]
+
+	info := &ImageInfo{
+		Title:       result.Title,
+		Description: result.Description,
+		Keywords:    stringsFromExif(result.Keywords),
+	}
+	if len(info.Keywords) == 0 {
+		info.Keywords = stringsFromExif(result.Subject)
+	}
+
+	if result.DateTimeOriginal != "" {
+		if date, err := time.Parse(exifDateLayout, result.DateTimeOriginal); err == nil {
+			info.Date = &date
+		}
+	}
+
+	return info, nil
+}
+
+// stringsFromExif normalises an exiftool field that may come back as either
+// a single string or an array of strings.
+func stringsFromExif(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{v}
+	case []interface{}:
+		var result []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	default:
+		return nil
+	}
+}
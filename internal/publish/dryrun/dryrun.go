@@ -0,0 +1,53 @@
+/*
+Copyright © 2020 Rob Allen <rob@akrabat.com>
+
+Use of this source code is governed by the MIT
+license that can be found in the LICENSE file or at
+https://akrabat.com/license/mit.
+*/
+
+// Package dryrun is the publish.Publisher implementation used for
+// `rodeo upload --dry-run`: it prints what it would have done and never
+// touches the network. It is registered under the name "dryrun".
+package dryrun
+
+import (
+	"context"
+	"fmt"
+	"github.com/akrabat/rodeo/internal"
+	"github.com/akrabat/rodeo/internal/publish"
+	"strings"
+	"time"
+)
+
+func init() {
+	publish.Register("dryrun", New)
+}
+
+// Publisher prints what it would publish, instead of publishing it.
+type Publisher struct{}
+
+// New builds a dry-run Publisher. It never fails, since it has no
+// credentials or network to validate.
+func New(config *internal.Config) (publish.Publisher, error) {
+	return &Publisher{}, nil
+}
+
+// Upload returns a PhotoRef with an empty ID, which the upload command
+// treats as "nothing to record".
+func (p *Publisher) Upload(ctx context.Context, path string, meta publish.Metadata) (publish.PhotoRef, error) {
+	fmt.Printf("Would upload %s as %q (tags: %s)\n", path, meta.Title, strings.Join(meta.Tags, ", "))
+	return publish.PhotoRef{}, nil
+}
+
+func (p *Publisher) SetDatePosted(ctx context.Context, photo publish.PhotoRef, date time.Time) error {
+	return nil
+}
+
+func (p *Publisher) AddToAlbum(ctx context.Context, photo publish.PhotoRef, album publish.AlbumRef) error {
+	return nil
+}
+
+func (p *Publisher) EnsureAlbum(ctx context.Context, name string) (publish.AlbumRef, error) {
+	return publish.AlbumRef{Name: name}, nil
+}
@@ -0,0 +1,101 @@
+/*
+Copyright © 2020 Rob Allen <rob@akrabat.com>
+
+Use of this source code is governed by the MIT
+license that can be found in the LICENSE file or at
+https://akrabat.com/license/mit.
+*/
+
+// Package fake is an in-memory publish.Publisher used from tests, so that
+// the rules engine and upload pipeline can be exercised end-to-end without
+// hitting Flickr. It is not registered under a config name; construct it
+// directly with New.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"github.com/akrabat/rodeo/internal/publish"
+	"sync"
+	"time"
+)
+
+// Upload is one call recorded by the Publisher.
+type Upload struct {
+	Path string
+	Meta publish.Metadata
+}
+
+// Publisher records every call made to it instead of publishing anywhere.
+type Publisher struct {
+	mu sync.Mutex
+
+	Uploads      []Upload
+	DatesPosted  map[string]time.Time
+	AlbumMembers map[string][]string // album id -> photo ids
+	Albums       map[string]publish.AlbumRef
+
+	nextPhotoID int
+	nextAlbumID int
+}
+
+// New returns an empty Publisher.
+func New() *Publisher {
+	return &Publisher{
+		DatesPosted:  make(map[string]time.Time),
+		AlbumMembers: make(map[string][]string),
+		Albums:       make(map[string]publish.AlbumRef),
+	}
+}
+
+func (p *Publisher) Upload(ctx context.Context, path string, meta publish.Metadata) (publish.PhotoRef, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nextPhotoID++
+	ref := publish.PhotoRef{ID: fmt.Sprintf("fake-%d", p.nextPhotoID)}
+	p.Uploads = append(p.Uploads, Upload{Path: path, Meta: meta})
+
+	return ref, nil
+}
+
+func (p *Publisher) SetDatePosted(ctx context.Context, photo publish.PhotoRef, date time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.DatesPosted[photo.ID] = date
+	return nil
+}
+
+// AddToAlbum adds photo to album. Like flickr.Publisher.AddToAlbum, a ref
+// with no ID means the album doesn't exist yet, so one is created here
+// unconditionally rather than checked for first: callers that want to
+// avoid creating the same album twice need to serialize "find or create"
+// for a given name themselves (see commands.albumResolver).
+func (p *Publisher) AddToAlbum(ctx context.Context, photo publish.PhotoRef, album publish.AlbumRef) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if album.ID == "" {
+		p.nextAlbumID++
+		album = publish.AlbumRef{ID: fmt.Sprintf("album-%d", p.nextAlbumID), Name: album.Name}
+		p.Albums[album.Name] = album
+	}
+
+	p.AlbumMembers[album.ID] = append(p.AlbumMembers[album.ID], photo.ID)
+	return nil
+}
+
+// EnsureAlbum reports the Albums entry for name. Like the other backends,
+// it never creates an album itself: if none has been created yet (via
+// AddToAlbum), it returns a ref with an empty ID.
+func (p *Publisher) EnsureAlbum(ctx context.Context, name string) (publish.AlbumRef, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if album, ok := p.Albums[name]; ok {
+		return album, nil
+	}
+
+	return publish.AlbumRef{Name: name}, nil
+}
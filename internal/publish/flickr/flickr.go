@@ -0,0 +1,218 @@
+/*
+Copyright © 2020 Rob Allen <rob@akrabat.com>
+
+Use of this source code is governed by the MIT
+license that can be found in the LICENSE file or at
+https://akrabat.com/license/mit.
+*/
+
+// Package flickr is the publish.Publisher implementation that talks to
+// Flickr, via gopkg.in/masci/flickr.v2. It is registered under the name
+// "flickr".
+package flickr
+
+import (
+	"context"
+	"fmt"
+	"github.com/akrabat/rodeo/internal"
+	"github.com/akrabat/rodeo/internal/publish"
+	"gopkg.in/masci/flickr.v2"
+	"gopkg.in/masci/flickr.v2/photos"
+	"gopkg.in/masci/flickr.v2/photosets"
+	"strconv"
+	"time"
+)
+
+func init() {
+	publish.Register("flickr", New)
+}
+
+// Publisher publishes photos to Flickr.
+type Publisher struct {
+	client *flickr.FlickrClient
+}
+
+// New builds a Flickr Publisher from rodeo's config.
+func New(config *internal.Config) (publish.Publisher, error) {
+	apiKey := config.Flickr.ApiKey
+	apiSecret := config.Flickr.ApiSecret
+	oauthToken := config.Flickr.OauthToken
+	oauthTokenSecret := config.Flickr.OauthSecret
+	if apiKey == "" || apiSecret == "" || oauthToken == "" || oauthTokenSecret == "" {
+		return nil, fmt.Errorf("flickr credentials are not configured; run 'rodeo authenticate' first")
+	}
+
+	client := flickr.NewFlickrClient(apiKey, apiSecret)
+	client.OAuthToken = oauthToken
+	client.OAuthTokenSecret = oauthTokenSecret
+
+	return &Publisher{client: client}, nil
+}
+
+func (p *Publisher) Upload(ctx context.Context, path string, meta publish.Metadata) (publish.PhotoRef, error) {
+	tags := make([]string, len(meta.Tags))
+	for i, tag := range meta.Tags {
+		tags[i] = fmt.Sprintf("\"%s\"", tag)
+	}
+
+	isPublic, isFriend, isFamily := visibilityFlags(meta.Visibility)
+
+	params := flickr.UploadParams{
+		Title:       meta.Title,
+		Description: meta.Description,
+		Tags:        tags,
+		IsPublic:    isPublic,
+		IsFriend:    isFriend,
+		IsFamily:    isFamily,
+		ContentType: contentTypeValue(meta.ContentType),
+		Hidden:      hiddenValue(meta.Hidden),
+		SafetyLevel: safetyLevelValue(meta.SafetyLevel),
+	}
+
+	response, err := flickr.UploadFile(p.client, path, &params)
+	if err != nil {
+		return publish.PhotoRef{}, err
+	}
+
+	photo := publish.PhotoRef{ID: response.ID}
+
+	if meta.License != nil {
+		if err := setLicense(p.client, photo.ID, *meta.License); err != nil {
+			return photo, err
+		}
+	}
+
+	return photo, nil
+}
+
+// setLicense sets a photo's license. flickr.v2's UploadParams has no
+// license field and the library exposes no flickr.photos.licenses.setLicense
+// wrapper, so this is hand-rolled via client.Args, the same way
+// photos.SetDates builds its request.
+func setLicense(client *flickr.FlickrClient, photoId string, license int) error {
+	client.Init()
+	client.EndpointUrl = flickr.API_ENDPOINT
+	client.HTTPVerb = "POST"
+	client.Args.Set("method", "flickr.photos.licenses.setLicense")
+	client.Args.Set("photo_id", photoId)
+	client.Args.Set("license_id", strconv.Itoa(license))
+	client.OAuthSign()
+
+	response := &flickr.BasicResponse{}
+	if err := flickr.DoPost(client, response); err != nil {
+		return err
+	}
+	if response.HasErrors() {
+		return fmt.Errorf("setting license on %s: %s", photoId, response.ErrorMsg())
+	}
+	return nil
+}
+
+func (p *Publisher) SetDatePosted(ctx context.Context, photo publish.PhotoRef, date time.Time) error {
+	datePosted := fmt.Sprintf("%d", date.Unix())
+	resp, err := photos.SetDates(p.client, photo.ID, datePosted, "")
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, resp.ErrorMsg())
+	}
+	return nil
+}
+
+// AddToAlbum adds photo to album. If album has no ID (see EnsureAlbum), it
+// doesn't exist on Flickr yet, so it's created here instead: Flickr's
+// photosets.create requires a primary photo id, and photo is the only one
+// available at this point in the pipeline.
+func (p *Publisher) AddToAlbum(ctx context.Context, photo publish.PhotoRef, album publish.AlbumRef) error {
+	if album.ID == "" {
+		resp, err := photosets.Create(p.client, album.Name, "", photo.ID)
+		if err != nil {
+			return fmt.Errorf("creating album %q: %w", album.Name, err)
+		}
+		if resp.HasErrors() {
+			return fmt.Errorf("creating album %q: %s", album.Name, resp.ErrorMsg())
+		}
+		return nil
+	}
+
+	resp, err := photosets.AddPhoto(p.client, album.ID, photo.ID)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, resp.ErrorMsg())
+	}
+	return nil
+}
+
+// EnsureAlbum finds the album called name. If none exists yet, it returns a
+// ref with no ID: Flickr has no "create an empty album" call, so creation is
+// deferred to AddToAlbum, which has the primary photo the creation call
+// requires.
+func (p *Publisher) EnsureAlbum(ctx context.Context, name string) (publish.AlbumRef, error) {
+	page := 1
+	for {
+		list, err := photosets.GetList(p.client, true, "", page)
+		if err != nil {
+			return publish.AlbumRef{}, fmt.Errorf("listing albums: %w", err)
+		}
+
+		for _, set := range list.Photosets.Items {
+			if set.Title == name {
+				return publish.AlbumRef{ID: set.Id, Name: set.Title}, nil
+			}
+		}
+
+		if page >= list.Photosets.Pages {
+			return publish.AlbumRef{Name: name}, nil
+		}
+		page++
+	}
+}
+
+// visibilityFlags maps Metadata.Visibility onto the three booleans Flickr's
+// upload API expects. An unset visibility keeps rodeo's original default of
+// public+friends+family.
+func visibilityFlags(visibility string) (isPublic bool, isFriend bool, isFamily bool) {
+	switch visibility {
+	case "public":
+		return true, false, false
+	case "friends":
+		return false, true, false
+	case "family":
+		return false, false, true
+	case "private":
+		return false, false, false
+	default:
+		return true, true, true
+	}
+}
+
+// safetyLevelValue maps Metadata.SafetyLevel onto Flickr's numeric
+// safety_level, defaulting to "safe".
+func safetyLevelValue(safetyLevel string) int {
+	switch safetyLevel {
+	case "moderate":
+		return 2
+	case "restricted":
+		return 3
+	default:
+		return 1
+	}
+}
+
+// contentTypeValue maps Metadata.ContentType onto Flickr's numeric
+// content_type, defaulting to "photo".
+func contentTypeValue(contentType string) int {
+	switch contentType {
+	case "screenshot":
+		return 2
+	case "other":
+		return 3
+	default:
+		return 1
+	}
+}
+
+// hiddenValue maps Metadata.Hidden onto Flickr's numeric is_hidden.
+func hiddenValue(hidden bool) int {
+	if hidden {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,85 @@
+/*
+Copyright © 2020 Rob Allen <rob@akrabat.com>
+
+Use of this source code is governed by the MIT
+license that can be found in the LICENSE file or at
+https://akrabat.com/license/mit.
+*/
+
+// Package publish abstracts the destination a photo is uploaded to, so that
+// the upload command doesn't need to know about Flickr specifically.
+// Backends register themselves under a name (see the flickr and dryrun
+// subpackages) and are selected at runtime via upload.backend.
+package publish
+
+import (
+	"context"
+	"fmt"
+	"github.com/akrabat/rodeo/internal"
+	"time"
+)
+
+// PhotoRef identifies a photo at the destination once it has been
+// uploaded.
+type PhotoRef struct {
+	ID string
+}
+
+// AlbumRef identifies an album (Flickr calls these photosets) at the
+// destination.
+type AlbumRef struct {
+	ID   string
+	Name string
+}
+
+// Metadata is everything rodeo knows about a photo that a Publisher might
+// want to apply at upload time.
+type Metadata struct {
+	Title       string
+	Description string
+	Tags        []string
+	Visibility  string // "public", "friends", "family" or "private"
+	SafetyLevel string // "safe", "moderate" or "restricted"
+	ContentType string // "photo", "screenshot" or "other"
+	Hidden      bool
+	License     *int
+}
+
+// Publisher is the destination a photo is uploaded to.
+type Publisher interface {
+	// Upload sends the file at path, along with meta, to the destination.
+	Upload(ctx context.Context, path string, meta Metadata) (PhotoRef, error)
+
+	// SetDatePosted backdates photo so that it appears in the destination's
+	// timeline at the point it was actually taken.
+	SetDatePosted(ctx context.Context, photo PhotoRef, date time.Time) error
+
+	// AddToAlbum adds photo to album. An album with no ID does not exist
+	// yet, so it is created, with photo in it.
+	AddToAlbum(ctx context.Context, photo PhotoRef, album AlbumRef) error
+
+	// EnsureAlbum finds an album called name, returning its ref. It does not
+	// create one: if none exists, the returned ref has an empty ID.
+	EnsureAlbum(ctx context.Context, name string) (AlbumRef, error)
+}
+
+// Factory builds a Publisher from rodeo's config. Backends call Register
+// with their Factory from an init() function.
+type Factory func(config *internal.Config) (Publisher, error)
+
+var factories = make(map[string]Factory)
+
+// Register makes a backend available under name. It is expected to be
+// called from a backend package's init() function.
+func Register(name string, factory Factory) {
+	factories[name] = factory
+}
+
+// Open builds the Publisher registered under name.
+func Open(name string, config *internal.Config) (Publisher, error) {
+	factory, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown publish backend %q (is it imported?)", name)
+	}
+	return factory(config)
+}
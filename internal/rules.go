@@ -0,0 +1,180 @@
+/*
+Copyright © 2020 Rob Allen <rob@akrabat.com>
+
+Use of this source code is governed by the MIT
+license that can be found in the LICENSE file or at
+https://akrabat.com/license/mit.
+*/
+
+package internal
+
+import (
+	"bytes"
+	"text/template"
+)
+
+// ResolvedAction is the result of folding every Rule that matches an
+// image's keywords into a single set of actions to apply.
+type ResolvedAction struct {
+	KeywordsToRemove    []string
+	AddTags             []string
+	Albums              []Album
+	Visibility          string
+	SafetyLevel         string
+	ContentType         string
+	Hidden              *bool
+	License             *int
+	TitleTemplate       string
+	DescriptionTemplate string
+}
+
+// ResolveRules evaluates every rule against keywords and folds their actions
+// together. Delete, Albums and AddTags accumulate across every matching
+// rule; the rest are "last write wins", so a later rule in config order
+// overrides a field set by an earlier one.
+func ResolveRules(rules []Rule, keywords []string) ResolvedAction {
+	var resolved ResolvedAction
+
+	for _, rule := range rules {
+		matched, ok := matchCondition(rule.Condition, keywords)
+		if !ok {
+			continue
+		}
+
+		if rule.Action.Delete {
+			resolved.KeywordsToRemove = append(resolved.KeywordsToRemove, matched...)
+		}
+		resolved = ApplyAction(resolved, rule.Action)
+	}
+
+	return resolved
+}
+
+// ApplyAction folds action into resolved using the same precedence as
+// ResolveRules: Albums and AddTags accumulate (each unioned, not
+// duplicated), everything else is "last write wins". It does not touch
+// KeywordsToRemove, since that is only meaningful alongside a Condition's
+// matched keywords (see ResolveRules); callers applying an unconditional
+// override (e.g. a per-file sidecar) should append to KeywordsToRemove
+// themselves.
+func ApplyAction(resolved ResolvedAction, action Action) ResolvedAction {
+	if len(action.Albums) > 0 {
+		resolved.Albums = unionAlbums(resolved.Albums, action.Albums)
+	}
+	if len(action.AddTags) > 0 {
+		resolved.AddTags = unionStrings(resolved.AddTags, action.AddTags)
+	}
+	if action.Visibility != "" {
+		resolved.Visibility = action.Visibility
+	}
+	if action.SafetyLevel != "" {
+		resolved.SafetyLevel = action.SafetyLevel
+	}
+	if action.ContentType != "" {
+		resolved.ContentType = action.ContentType
+	}
+	if action.Hidden != nil {
+		resolved.Hidden = action.Hidden
+	}
+	if action.License != nil {
+		resolved.License = action.License
+	}
+	if action.Title != "" {
+		resolved.TitleTemplate = action.Title
+	}
+	if action.Description != "" {
+		resolved.DescriptionTemplate = action.Description
+	}
+
+	return resolved
+}
+
+// matchCondition reports whether c applies to keywords, and if so, the
+// keywords that triggered it (used by Action.Delete).
+func matchCondition(c Condition, keywords []string) (matched []string, ok bool) {
+	// If the image has every `excludes_all` keyword, the rule is ignored.
+	if len(c.ExcludesAll) > 0 {
+		if intersection := Intersection(keywords, c.ExcludesAll); len(intersection) == len(c.ExcludesAll) {
+			return nil, false
+		}
+	}
+
+	// If the image has any `excludes_any` keyword, the rule is ignored.
+	if len(c.ExcludesAny) > 0 {
+		if intersection := Intersection(keywords, c.ExcludesAny); len(intersection) > 0 {
+			return nil, false
+		}
+	}
+
+	if len(c.IncludesAll) > 0 {
+		intersection := Intersection(keywords, c.IncludesAll)
+		if len(intersection) != len(c.IncludesAll) {
+			return nil, false
+		}
+		return intersection, true
+	}
+
+	if len(c.IncludesAny) > 0 {
+		intersection := Intersection(keywords, c.IncludesAny)
+		if len(intersection) == 0 {
+			return nil, false
+		}
+		return intersection, true
+	}
+
+	return nil, false
+}
+
+// unionStrings appends the items of b that aren't already in a.
+func unionStrings(a []string, b []string) []string {
+	seen := make(map[string]struct{}, len(a))
+	for _, item := range a {
+		seen[item] = struct{}{}
+	}
+
+	for _, item := range b {
+		if _, ok := seen[item]; !ok {
+			a = append(a, item)
+			seen[item] = struct{}{}
+		}
+	}
+
+	return a
+}
+
+// unionAlbums appends the items of b that aren't already in a, identifying
+// an album the same way the rest of the pipeline does (Album.String():
+// its name, falling back to its id), so two rules targeting the same album
+// don't add a photo to it twice.
+func unionAlbums(a []Album, b []Album) []Album {
+	seen := make(map[string]struct{}, len(a))
+	for _, album := range a {
+		seen[album.String()] = struct{}{}
+	}
+
+	for _, album := range b {
+		key := album.String()
+		if _, ok := seen[key]; !ok {
+			a = append(a, album)
+			seen[key] = struct{}{}
+		}
+	}
+
+	return a
+}
+
+// RenderTemplate renders a Go text/template string against info, for use as
+// a fallback title/description when the image has none of its own.
+func RenderTemplate(tmpl string, info *ImageInfo) (string, error) {
+	t, err := template.New("rule").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, info); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
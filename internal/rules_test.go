@@ -0,0 +1,198 @@
+/*
+Copyright © 2020 Rob Allen <rob@akrabat.com>
+
+Use of this source code is governed by the MIT
+license that can be found in the LICENSE file or at
+https://akrabat.com/license/mit.
+*/
+
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func boolPtr(b bool) *bool { return &b }
+func intPtr(i int) *int    { return &i }
+
+func TestResolveRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []Rule
+		keywords []string
+		want     ResolvedAction
+	}{
+		{
+			name:     "no rules",
+			rules:    nil,
+			keywords: []string{"family"},
+			want:     ResolvedAction{},
+		},
+		{
+			name: "non-matching rule is ignored",
+			rules: []Rule{
+				{
+					Condition: Condition{IncludesAny: []string{"private"}},
+					Action:    Action{Visibility: "private"},
+				},
+			},
+			keywords: []string{"family"},
+			want:     ResolvedAction{},
+		},
+		{
+			name: "delete accumulates matched keywords across rules",
+			rules: []Rule{
+				{
+					Condition: Condition{IncludesAny: []string{"private"}},
+					Action:    Action{Delete: true},
+				},
+				{
+					Condition: Condition{IncludesAny: []string{"draft"}},
+					Action:    Action{Delete: true},
+				},
+			},
+			keywords: []string{"private", "draft", "family"},
+			want:     ResolvedAction{KeywordsToRemove: []string{"private", "draft"}},
+		},
+		{
+			name: "albums are unioned, not duplicated",
+			rules: []Rule{
+				{
+					Condition: Condition{IncludesAny: []string{"family"}},
+					Action:    Action{Albums: []Album{{Name: "Family"}}},
+				},
+				{
+					Condition: Condition{IncludesAny: []string{"2026"}},
+					Action:    Action{Albums: []Album{{Name: "Family"}, {Name: "2026"}}},
+				},
+			},
+			keywords: []string{"family", "2026"},
+			want: ResolvedAction{
+				Albums: []Album{{Name: "Family"}, {Name: "2026"}},
+			},
+		},
+		{
+			name: "albums accumulate across matching rules",
+			rules: []Rule{
+				{
+					Condition: Condition{IncludesAny: []string{"family"}},
+					Action:    Action{Albums: []Album{{Name: "Family"}}},
+				},
+				{
+					Condition: Condition{IncludesAny: []string{"2026"}},
+					Action:    Action{Albums: []Album{{Name: "2026"}}},
+				},
+			},
+			keywords: []string{"family", "2026"},
+			want: ResolvedAction{
+				Albums: []Album{{Name: "Family"}, {Name: "2026"}},
+			},
+		},
+		{
+			name: "add_tags is unioned, not duplicated",
+			rules: []Rule{
+				{
+					Condition: Condition{IncludesAny: []string{"family"}},
+					Action:    Action{AddTags: []string{"family", "2026"}},
+				},
+				{
+					Condition: Condition{IncludesAny: []string{"2026"}},
+					Action:    Action{AddTags: []string{"2026", "vacation"}},
+				},
+			},
+			keywords: []string{"family", "2026"},
+			want: ResolvedAction{
+				AddTags: []string{"family", "2026", "vacation"},
+			},
+		},
+		{
+			name: "later rule overrides an earlier rule's scalar fields",
+			rules: []Rule{
+				{
+					Condition: Condition{IncludesAny: []string{"family"}},
+					Action:    Action{Visibility: "friends", SafetyLevel: "safe"},
+				},
+				{
+					Condition: Condition{IncludesAny: []string{"private"}},
+					Action:    Action{Visibility: "private"},
+				},
+			},
+			keywords: []string{"family", "private"},
+			want: ResolvedAction{
+				Visibility:  "private",
+				SafetyLevel: "safe",
+			},
+		},
+		{
+			name: "hidden and license pointers follow last-write-wins",
+			rules: []Rule{
+				{
+					Condition: Condition{IncludesAny: []string{"family"}},
+					Action:    Action{Hidden: boolPtr(true), License: intPtr(4)},
+				},
+				{
+					Condition: Condition{IncludesAny: []string{"public"}},
+					Action:    Action{Hidden: boolPtr(false)},
+				},
+			},
+			keywords: []string{"family", "public"},
+			want: ResolvedAction{
+				Hidden:  boolPtr(false),
+				License: intPtr(4),
+			},
+		},
+		{
+			name: "excludes_all suppresses an otherwise matching rule",
+			rules: []Rule{
+				{
+					Condition: Condition{IncludesAny: []string{"family"}, ExcludesAll: []string{"family", "private"}},
+					Action:    Action{Visibility: "private"},
+				},
+			},
+			keywords: []string{"family", "private"},
+			want:     ResolvedAction{},
+		},
+		{
+			name: "title and description templates follow last-write-wins",
+			rules: []Rule{
+				{
+					Condition: Condition{IncludesAny: []string{"family"}},
+					Action:    Action{Title: "{{.Title}}", Description: "first"},
+				},
+				{
+					Condition: Condition{IncludesAny: []string{"2026"}},
+					Action:    Action{Description: "second"},
+				},
+			},
+			keywords: []string{"family", "2026"},
+			want: ResolvedAction{
+				TitleTemplate:       "{{.Title}}",
+				DescriptionTemplate: "second",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ResolveRules(tt.rules, tt.keywords)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveRules() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	info := &ImageInfo{Title: "Sunset"}
+
+	got, err := RenderTemplate("Photo: {{.Title}}", info)
+	if err != nil {
+		t.Fatalf("RenderTemplate() error = %v", err)
+	}
+
+	want := "Photo: Sunset"
+	if got != want {
+		t.Errorf("RenderTemplate() = %q, want %q", got, want)
+	}
+}
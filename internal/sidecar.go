@@ -0,0 +1,155 @@
+/*
+Copyright © 2020 Rob Allen <rob@akrabat.com>
+
+Use of this source code is governed by the MIT
+license that can be found in the LICENSE file or at
+https://akrabat.com/license/mit.
+*/
+
+package internal
+
+import (
+	"encoding/xml"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Sidecar is a per-file override read from a YAML or XMP file next to an
+// image. Its fields mirror Action, so the same rule vocabulary used in the
+// config applies to one-off, version-controlled overrides.
+type Sidecar struct {
+	Title       string   `yaml:"title"`
+	Description string   `yaml:"description"`
+	AddTags     []string `yaml:"add_tags"`
+	RemoveTags  []string `yaml:"remove_tags"`
+	Visibility  string   `yaml:"visibility"`
+	SafetyLevel string   `yaml:"safety_level"`
+	ContentType string   `yaml:"content_type"`
+	Hidden      *bool    `yaml:"hidden"`
+	License     *int     `yaml:"license"`
+	Albums      []Album  `yaml:"albums"`
+}
+
+// FindSidecar locates the sidecar for imageFilename, trying (in order)
+// "<image>.yml", "<image-without-ext>.yml" and "<image-without-ext>.xmp".
+// It returns an empty path if none exists.
+func FindSidecar(imageFilename string) string {
+	ext := filepath.Ext(imageFilename)
+	withoutExt := imageFilename[:len(imageFilename)-len(ext)]
+
+	for _, candidate := range []string{imageFilename + ".yml", withoutExt + ".yml", withoutExt + ".xmp"} {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+
+	return ""
+}
+
+// LoadSidecar reads and parses the sidecar for imageFilename, or returns
+// nil if there isn't one.
+func LoadSidecar(imageFilename string) (*Sidecar, error) {
+	path := FindSidecar(imageFilename)
+	if path == "" {
+		return nil, nil
+	}
+
+	if filepath.Ext(path) == ".xmp" {
+		return loadXMPSidecar(path)
+	}
+
+	return loadYAMLSidecar(path)
+}
+
+func loadYAMLSidecar(path string) (*Sidecar, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var sidecar Sidecar
+	if err := yaml.Unmarshal(data, &sidecar); err != nil {
+		return nil, err
+	}
+
+	return &sidecar, nil
+}
+
+// xmpDocument covers the handful of Dublin Core / XMP fields rodeo reads
+// out of an XMP sidecar: title, description and the flat tag list that
+// most DAM tools (including exiftool) write to dc:subject.
+type xmpDocument struct {
+	RDF struct {
+		Description struct {
+			Title struct {
+				Alt struct {
+					Items []string `xml:"li"`
+				} `xml:"Alt"`
+			} `xml:"title"`
+			Description struct {
+				Alt struct {
+					Items []string `xml:"li"`
+				} `xml:"Alt"`
+			} `xml:"description"`
+			Subject struct {
+				Bag struct {
+					Items []string `xml:"li"`
+				} `xml:"Bag"`
+			} `xml:"subject"`
+		} `xml:"Description"`
+	} `xml:"RDF"`
+}
+
+func loadXMPSidecar(path string) (*Sidecar, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc xmpDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	desc := doc.RDF.Description
+	sidecar := &Sidecar{
+		AddTags: desc.Subject.Bag.Items,
+	}
+	if len(desc.Title.Alt.Items) > 0 {
+		sidecar.Title = desc.Title.Alt.Items[0]
+	}
+	if len(desc.Description.Alt.Items) > 0 {
+		sidecar.Description = desc.Description.Alt.Items[0]
+	}
+
+	return sidecar, nil
+}
+
+// AuditSidecar records what rodeo actually sent to Flickr for a photo. It
+// is written as "<image>.rodeo.yml" when upload.store_upload_list_in_image_dir
+// is enabled, giving an audit trail of uploads alongside the images
+// themselves.
+type AuditSidecar struct {
+	PhotoId     string   `yaml:"photo_id"`
+	Title       string   `yaml:"title"`
+	Description string   `yaml:"description"`
+	Tags        []string `yaml:"tags"`
+	Visibility  string   `yaml:"visibility"`
+	SafetyLevel string   `yaml:"safety_level"`
+	ContentType string   `yaml:"content_type"`
+	Hidden      bool     `yaml:"hidden"`
+	License     *int     `yaml:"license,omitempty"`
+	Albums      []string `yaml:"albums"`
+}
+
+// WriteAuditSidecar writes audit as "<imageFilename>.rodeo.yml".
+func WriteAuditSidecar(imageFilename string, audit AuditSidecar) error {
+	data, err := yaml.Marshal(audit)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(imageFilename+".rodeo.yml", data, 0664)
+}
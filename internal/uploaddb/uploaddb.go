@@ -0,0 +1,266 @@
+/*
+Copyright © 2020 Rob Allen <rob@akrabat.com>
+
+Use of this source code is governed by the MIT
+license that can be found in the LICENSE file or at
+https://akrabat.com/license/mit.
+*/
+
+// Package uploaddb is a SQLite-backed replacement for the old
+// rodeo-uploaded-files.json registry. Uploads are keyed by the SHA-256 of
+// the file's contents rather than by basename, so renaming or moving a
+// photo no longer breaks dedup, and two files that happen to share a name
+// in different directories no longer collide.
+package uploaddb
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS uploads (
+	sha256      TEXT PRIMARY KEY,
+	photo_id    TEXT NOT NULL,
+	filename    TEXT NOT NULL,
+	size        INTEGER NOT NULL,
+	mtime       INTEGER NOT NULL,
+	uploaded_at INTEGER NOT NULL,
+	title       TEXT,
+	tags        TEXT
+);
+`
+
+// Upload is a single row of the uploads table.
+type Upload struct {
+	SHA256     string
+	PhotoID    string
+	Filename   string
+	Size       int64
+	Mtime      int64
+	UploadedAt int64
+	Title      string
+	Tags       string
+}
+
+// DB wraps the SQLite connection used to track uploaded files.
+type DB struct {
+	conn *sql.DB
+}
+
+// Open opens (creating if necessary) the SQLite database at path and
+// ensures the uploads table exists.
+func Open(path string) (*DB, error) {
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The upload pipeline's reader and recorder goroutines can both reach
+	// this DB concurrently (see commands.uploadDBSet). go-sqlite3
+	// connections aren't safe for concurrent writers, so cap the pool to one,
+	// which makes database/sql queue the other callers, and set a busy
+	// timeout so a lock held by another process is waited on instead of
+	// failing with "database is locked".
+	conn.SetMaxOpenConns(1)
+	if _, err := conn.Exec("PRAGMA busy_timeout = 5000"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if _, err := conn.Exec(schema); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &DB{conn: conn}, nil
+}
+
+// Close closes the underlying database connection.
+func (db *DB) Close() error {
+	return db.conn.Close()
+}
+
+// HashFile returns the hex-encoded SHA-256 of a file's contents, streaming
+// it rather than reading the whole file into memory.
+func HashFile(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// FindBySHA256 returns the upload recorded for the given content hash, or
+// nil if there isn't one.
+func (db *DB) FindBySHA256(sha256 string) (*Upload, error) {
+	row := db.conn.QueryRow(
+		`SELECT sha256, photo_id, filename, size, mtime, uploaded_at, title, tags
+		 FROM uploads WHERE sha256 = ?`, sha256)
+
+	var u Upload
+	err := row.Scan(&u.SHA256, &u.PhotoID, &u.Filename, &u.Size, &u.Mtime, &u.UploadedAt, &u.Title, &u.Tags)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+// FindByPhotoID returns the upload recorded for the given Flickr photo id,
+// or nil if there isn't one. Used by `rodeo pull` to recognize photos that
+// `rodeo upload` already knows about, so they aren't downloaded again.
+func (db *DB) FindByPhotoID(photoId string) (*Upload, error) {
+	row := db.conn.QueryRow(
+		`SELECT sha256, photo_id, filename, size, mtime, uploaded_at, title, tags
+		 FROM uploads WHERE photo_id = ?`, photoId)
+
+	var u Upload
+	err := row.Scan(&u.SHA256, &u.PhotoID, &u.Filename, &u.Size, &u.Mtime, &u.UploadedAt, &u.Title, &u.Tags)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+// FindByFile hashes filename and looks it up via FindBySHA256.
+func (db *DB) FindByFile(filename string) (*Upload, error) {
+	sha, err := HashFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.FindBySHA256(sha)
+}
+
+// Record inserts or replaces the upload for u.SHA256.
+func (db *DB) Record(u Upload) error {
+	_, err := db.conn.Exec(
+		`INSERT OR REPLACE INTO uploads
+		 (sha256, photo_id, filename, size, mtime, uploaded_at, title, tags)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		u.SHA256, u.PhotoID, u.Filename, u.Size, u.Mtime, u.UploadedAt, u.Title, u.Tags)
+	return err
+}
+
+// List returns every recorded upload, most recently uploaded first.
+func (db *DB) List() ([]Upload, error) {
+	rows, err := db.conn.Query(
+		`SELECT sha256, photo_id, filename, size, mtime, uploaded_at, title, tags
+		 FROM uploads ORDER BY uploaded_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanUploads(rows)
+}
+
+// Search returns every recorded upload whose filename, title or tags
+// contain query.
+func (db *DB) Search(query string) ([]Upload, error) {
+	like := "%" + query + "%"
+	rows, err := db.conn.Query(
+		`SELECT sha256, photo_id, filename, size, mtime, uploaded_at, title, tags
+		 FROM uploads
+		 WHERE filename LIKE ? OR title LIKE ? OR tags LIKE ?
+		 ORDER BY uploaded_at DESC`, like, like, like)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanUploads(rows)
+}
+
+// Remove deletes the recorded upload for the given content hash.
+func (db *DB) Remove(sha256 string) error {
+	_, err := db.conn.Exec(`DELETE FROM uploads WHERE sha256 = ?`, sha256)
+	return err
+}
+
+func scanUploads(rows *sql.Rows) ([]Upload, error) {
+	var uploads []Upload
+	for rows.Next() {
+		var u Upload
+		if err := rows.Scan(&u.SHA256, &u.PhotoID, &u.Filename, &u.Size, &u.Mtime, &u.UploadedAt, &u.Title, &u.Tags); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, u)
+	}
+	return uploads, rows.Err()
+}
+
+// MigrateJSONFile imports the legacy rodeo-uploaded-files.json registry
+// (a filename -> photo id map) at jsonPath into db, hashing each referenced
+// file that can still be found relative to baseDir. It returns the number
+// of entries imported, skipping (without failing) any file it can no longer
+// find or read.
+func (db *DB) MigrateJSONFile(jsonPath string, baseDir string) (int, error) {
+	data, err := ioutil.ReadFile(jsonPath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var filenames map[string]string
+	if err := json.Unmarshal(data, &filenames); err != nil {
+		return 0, err
+	}
+
+	imported := 0
+	for filename, photoId := range filenames {
+		path := filename
+		if baseDir != "" {
+			path = baseDir + "/" + filename
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		sha, err := HashFile(path)
+		if err != nil {
+			continue
+		}
+
+		err = db.Record(Upload{
+			SHA256:     sha,
+			PhotoID:    photoId,
+			Filename:   filename,
+			Size:       fi.Size(),
+			Mtime:      fi.ModTime().Unix(),
+			UploadedAt: fi.ModTime().Unix(),
+		})
+		if err != nil {
+			return imported, err
+		}
+		imported++
+	}
+
+	return imported, nil
+}
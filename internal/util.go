@@ -0,0 +1,43 @@
+/*
+Copyright © 2020 Rob Allen <rob@akrabat.com>
+
+Use of this source code is governed by the MIT
+license that can be found in the LICENSE file or at
+https://akrabat.com/license/mit.
+*/
+
+package internal
+
+// Intersection returns the items that appear in both a and b.
+func Intersection(a []string, b []string) []string {
+	set := make(map[string]struct{}, len(b))
+	for _, item := range b {
+		set[item] = struct{}{}
+	}
+
+	var result []string
+	for _, item := range a {
+		if _, ok := set[item]; ok {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+// Difference returns the items in a that do not appear in b.
+func Difference(a []string, b []string) []string {
+	set := make(map[string]struct{}, len(b))
+	for _, item := range b {
+		set[item] = struct{}{}
+	}
+
+	var result []string
+	for _, item := range a {
+		if _, ok := set[item]; !ok {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}